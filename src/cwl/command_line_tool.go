@@ -1,9 +1,12 @@
 package cwl
 
 import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
-	"log"
 	"sort"
+	"strings"
 )
 
 func (self CommandLineTool) NewGraphState(inputs JSONDict) JSONDict {
@@ -49,12 +52,14 @@ func (self CommandLineTool) GenerateJob(step string, graphState JSONDict) (Job,
 	} else {
 		args, err := self.Evaluate(i.(JSONDict))
 		if err != nil {
-			log.Printf("Job Eval Error: %s", err)
+			Debugf("Job Eval Error: %s", err)
 			return Job{}, err
 		}
 		stdout := self.Stdout
 		stderr := self.Stderr
 		stdin := self.Stdin
+		randomStdout := false
+		randomStderr := false
 
 		outputs := map[string]Schema{}
 		for k, v := range self.Outputs {
@@ -63,21 +68,23 @@ func (self CommandLineTool) GenerateJob(step string, graphState JSONDict) (Job,
 			//create the temp files that will be used to store the output
 			if v.Schema.TypeName == "stdout" {
 				if stdout == "" {
-					stdout = "__cwl_stdout"
+					stdout = randomName()
+					randomStdout = true
 				}
 				for i := range args {
 					if args[i].Id == outputs[k].Id {
-						args[i].File.Glob = stdout
+						args[i].File.Glob = []string{stdout}
 					}
 				}
 			}
 			if v.Schema.TypeName == "stderr" {
 				if stderr == "" {
-					stderr = "__cwl_stderr"
+					stderr = randomName()
+					randomStderr = true
 				}
 				for i := range args {
 					if args[i].Id == outputs[k].Id {
-						args[i].File.Glob = stderr
+						args[i].File.Glob = []string{stderr}
 					}
 				}
 			}
@@ -88,26 +95,95 @@ func (self CommandLineTool) GenerateJob(step string, graphState JSONDict) (Job,
 		}
 
 		dockerImage := ""
+		docker := DockerRequirement{}
+		enableReuse := ""
+		loadListing := self.LoadListing
+		workDir := InitialWorkDirRequirement{}
+		env := map[string]string{}
+		shellCommand := false
+		timeLimit := ""
+		networkAccess := ""
+		var resources *ResourceRequirement
 		for _, i := range self.Requirements {
+			if a, ok := i.(ResourceRequirement); ok {
+				resources = &a
+			}
+			if a, ok := i.(ToolTimeLimit); ok {
+				timeLimit = a.TimeLimit
+			}
+			if a, ok := i.(NetworkAccess); ok {
+				networkAccess = a.NetworkAccess
+			}
+			if _, ok := i.(ShellCommandRequirement); ok {
+				shellCommand = true
+			}
+			if a, ok := i.(LoadListingRequirement); ok {
+				loadListing = a.LoadListing
+			}
+			if a, ok := i.(InitialWorkDirRequirement); ok {
+				workDir = a
+			}
+			if a, ok := i.(EnvVarRequirement); ok {
+				for k, v := range a.EnvDef {
+					env[k] = v
+				}
+			}
 			if a, ok := i.(DockerRequirement); ok {
-				dockerImage = a.DockerPull
+				dockerImage = a.ImageName()
+				docker = a
+			}
+			if a, ok := i.(WorkReuseRequirement); ok {
+				enableReuse = a.EnableReuse
 			}
 		}
 
 		return Job{JobType: COMMAND,
-			Cmd:          args,
-			Stderr:       stderr,
-			Stdout:       stdout,
-			Stdin:        stdin,
-			InputData:    i.(JSONDict),
-			DockerImage:  dockerImage,
-			SuccessCodes: self.SuccessCodes,
-			Outputs:      outputs,
-			Inputs:       inputs,
+			Cmd:           args,
+			Stderr:        stderr,
+			Stdout:        stdout,
+			Stdin:         stdin,
+			RandomStdout:  randomStdout,
+			RandomStderr:  randomStderr,
+			InputData:     i.(JSONDict),
+			DockerImage:   dockerImage,
+			Docker:        docker,
+			SuccessCodes:  self.SuccessCodes,
+			EnableReuse:   enableReuse,
+			LoadListing:   loadListing,
+			WorkDir:       workDir,
+			Env:           env,
+			ShellCommand:  shellCommand,
+			Resources:     resources,
+			TimeLimit:     timeLimit,
+			NetworkAccess: networkAccess,
+			Outputs:       outputs,
+			Inputs:        inputs,
 		}, nil
 	}
 }
 
+// randomName returns a unique file name for a stdout or stderr output
+// without one
+func randomName() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ImageName is the name the image of a DockerRequirement is run as. Images
+// that are loaded, built or imported without a dockerImageId are tagged
+// with a name derived from their source.
+func (self DockerRequirement) ImageName() string {
+	if self.DockerImageId != "" {
+		return self.DockerImageId
+	}
+	if self.DockerPull != "" {
+		return self.DockerPull
+	}
+	sum := sha1.Sum([]byte(strings.Join([]string{self.DockerFile, self.DockerLoad, self.DockerImport}, "\x00")))
+	return "cwl-go-" + hex.EncodeToString(sum[:])
+}
+
 type jobArgArray []JobArgument
 
 func (self jobArgArray) Len() int {
@@ -126,7 +202,7 @@ func (self jobArgArray) Swap(i, j int) {
 }
 
 func (self *CommandLineTool) Evaluate(inputs JSONDict) ([]JobArgument, error) {
-	log.Printf("CommandLineTool Evalute")
+	Debugf("CommandLineTool Evalute")
 
 	args := make(jobArgArray, 0, len(self.Arguments)+len(self.Inputs))
 
@@ -138,7 +214,7 @@ func (self *CommandLineTool) Evaluate(inputs JSONDict) ([]JobArgument, error) {
 	for _, x := range self.Arguments {
 		new_args, err := x.Evaluate(inputs)
 		if err != nil {
-			log.Printf("Argument Error: %s", err)
+			Debugf("Argument Error: %s", err)
 			return []JobArgument{}, err
 		}
 		args = append(args, new_args)
@@ -147,7 +223,7 @@ func (self *CommandLineTool) Evaluate(inputs JSONDict) ([]JobArgument, error) {
 	for _, x := range self.Inputs {
 		new_args, err := x.Evaluate(inputs)
 		if err != nil {
-			log.Printf("Input Error: %s", err)
+			Debugf("Input Error: %s", err)
 			return []JobArgument{}, err
 		}
 		args = append(args, new_args)
@@ -157,14 +233,14 @@ func (self *CommandLineTool) Evaluate(inputs JSONDict) ([]JobArgument, error) {
 	for _, x := range self.Outputs {
 		new_args, err := x.Evaluate(inputs)
 		if err != nil {
-			log.Printf("Output Error: %s", err)
+			Debugf("Output Error: %s", err)
 			return []JobArgument{}, err
 		}
 		args = append(args, new_args)
 	}
 
 	sort.Stable(args)
-	//log.Printf("Out: %v", args)
+	//Debugf("Out: %v", args)
 	return args, nil
 }
 
@@ -177,109 +253,256 @@ func (self *Schema) IsOptional() bool {
 	return false
 }
 
-func (self *Schema) SchemaEvaluate(value interface{}) (JobArgument, error) {
-	out_args := JobArgument{
-		Id:       self.Id,
-		Join:     self.ItemSeparator,
-		Position: self.Position,
-		Bound:    self.Bound,
-		RawValue: value,
+// NonNullType returns the type a value of the schema has when it is not
+// null, unwrapping optional unions and inline type definitions
+func (self *Schema) NonNullType() Schema {
+	if self.TypeName == "array_holder" && len(self.Types) > 0 {
+		return self.Types[0].NonNullType()
 	}
-
-	typeName := self.TypeName
-
-	if typeName != "array_holder" {
-		for _, a := range self.Types {
-			if a.TypeName == "null" && value == nil {
-				typeName = "null"
-			} else {
-				//BUG: this is assuming a binary choice null vs something...
-				typeName = a.TypeName
-			}
+	if self.TypeName != "" {
+		return *self
+	}
+	for _, a := range self.Types {
+		if a.TypeName != "null" {
+			return a.NonNullType()
 		}
 	}
+	return *self
+}
 
-	if typeName == "File" {
-		if base, ok := value.(map[interface{}]interface{}); ok {
-			if class, ok := base["class"]; ok {
-				if class.(string) == "File" {
-					loc := base["location"].(string)
-					out_args.File = &JobFile{Id: self.Id, Location: loc, LoadContents: self.LoadContents}
-					out_args.Value = "$(self.path)"
-				} else {
-					log.Printf("Unknown class %s", class)
-				}
-			} else {
-				log.Printf("Input map has no class")
+// Validate checks that value is of the type described by the schema
+func (self *Schema) Validate(value interface{}) error {
+	if base, ok := value.(JSONDict); ok {
+		value = map[interface{}]interface{}(base)
+	}
+	if self.TypeName == "" || self.TypeName == "array_holder" {
+		for _, t := range self.Types {
+			if t.Validate(value) == nil {
+				return nil
 			}
-		} else {
-			log.Printf("File input not formatted correctly: %#v", value)
-		}
-	} else if typeName == "Directory" {
-		if base, ok := value.(map[interface{}]interface{}); ok {
-			if class, ok := base["class"]; ok {
-				if class.(string) == "Directory" {
-					loc := base["location"].(string)
-					out_args.File = &JobFile{Id: self.Id, Location: loc, Dir: true}
-					out_args.Value = "$(self.path)"
-				} else {
-					log.Printf("Unknown class %s", class)
-				}
-			} else {
-				log.Printf("Input map has no class: %#v", base)
+		}
+		return fmt.Errorf("%s does not match any type of %s", JSValueString(value), self.typeString())
+	}
+	ok := false
+	switch self.TypeName {
+	case "Any":
+		ok = value != nil
+	case "null":
+		ok = value == nil
+	case "boolean":
+		_, ok = value.(bool)
+	case "int", "long":
+		switch value.(type) {
+		case int, int32, int64:
+			ok = true
+		}
+	case "float", "double":
+		switch value.(type) {
+		case int, int32, int64, float32, float64:
+			ok = true
+		}
+	case "string":
+		_, ok = value.(string)
+	case "File", "Directory", "stdout", "stderr":
+		class := "File"
+		if self.TypeName == "Directory" {
+			class = "Directory"
+		}
+		if base, isMap := value.(map[interface{}]interface{}); isMap {
+			ok = base["class"] == class
+		}
+	case "record":
+		base, isMap := value.(map[interface{}]interface{})
+		if !isMap {
+			break
+		}
+		for _, f := range self.Fields {
+			if err := f.Validate(base[f.Name]); err != nil {
+				return fmt.Errorf("Field %s: %s", f.Name, err)
 			}
-		} else {
-			log.Printf("Directory input not formatted correctly: %#v", value)
 		}
-	} else if typeName == "int" {
-		out_args.Value = fmt.Sprintf("%d", value.(int))
-	} else if typeName == "boolean" {
-		if value.(bool) {
-			out_args.Prefix = self.Prefix
-		} else {
-			out_args.Prefix = ""
+		ok = true
+	case "array":
+		base, isArray := value.([]interface{})
+		if !isArray {
+			break
 		}
-	} else if typeName == "Any" {
-		out_args.Value = fmt.Sprintf("%s", value)
-	} else if typeName == "array_holder" {
-		o, err := self.Types[0].SchemaEvaluate(value)
-		if err != nil {
-			return JobArgument{}, fmt.Errorf("Bad array '%s' (%#v): %s", typeName, *self, err)
-		}
-		out_args = o
-		out_args.Join = self.ItemSeparator
-		out_args.Position = self.Position
-		out_args.Bound = self.Bound
-	} else if typeName == "array" {
-		if base, ok := value.([]interface{}); ok {
-			log.Printf("Evalutate ArrayItem Schema: %#v", self)
+		if self.Items != nil {
 			for _, i := range base {
-				e, err := self.Items.SchemaEvaluate(i)
-				if err != nil {
-					return JobArgument{}, err
-				}
-				if self.Prefix != "" {
-					out_args.Children = append(out_args.Children, JobArgument{Id: self.Id, Value: self.Prefix})
+				if err := self.Items.Validate(i); err != nil {
+					return err
 				}
-				out_args.Children = append(out_args.Children, e)
 			}
 		}
-	} else {
-		return JobArgument{}, fmt.Errorf("Unknown Type '%s' (%#v)", typeName, *self)
+		ok = true
+	default:
+		//named schemas are not resolved here
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("%s is not of type %s", JSValueString(value), self.typeString())
+	}
+	return nil
+}
+
+func (self *Schema) typeString() string {
+	if self.TypeName == "array" && self.Items != nil {
+		return self.Items.typeString() + "[]"
 	}
-	if self.Prefix != "" && typeName != "array" && typeName != "boolean" {
-		out_args.Prefix = self.Prefix
+	if self.TypeName != "" && self.TypeName != "array_holder" {
+		return self.TypeName
+	}
+	t := []string{}
+	for _, i := range self.Types {
+		t = append(t, i.typeString())
+	}
+	return "[" + strings.Join(t, ", ") + "]"
+}
+
+// SchemaEvaluate binds value to the schema, returning an argument for the
+// schema's own inputBinding with children for the bindings nested in
+// array items and record fields
+func (self *Schema) SchemaEvaluate(value interface{}) (JobArgument, error) {
+	if base, ok := value.(JSONDict); ok {
+		value = map[interface{}]interface{}(base)
+	}
+	out_args := JobArgument{
+		Id:           self.Id,
+		Join:         self.ItemSeparator,
+		Position:     self.Position,
+		Prefix:       self.Prefix,
+		Bound:        self.Bound,
+		NoSeparate:   self.NoSeparate,
+		NoShellQuote: self.NoShellQuote,
+		ValueFrom:    self.ValueFrom,
+		RawValue:     value,
+	}
+
+	if value == nil {
+		return out_args, nil
+	}
+	t, member, err := self.valueType(value)
+	if err != nil {
+		return JobArgument{}, err
+	}
+
+	switch t.TypeName {
+	case "File":
+		base, ok := value.(map[interface{}]interface{})
+		if !ok || base["class"] != "File" {
+			return JobArgument{}, fmt.Errorf("Input '%s' is not a File: %s", self.Id, JSValueString(value))
+		}
+		loc, _ := base["location"].(string)
+		out_args.File = &JobFile{Id: self.Id, Location: loc, LoadContents: self.LoadContents}
+		if loc == "" {
+			out_args.File.Literal = base
+		}
+		if sf, ok := base["secondaryFiles"].([]interface{}); ok {
+			for _, i := range sf {
+				if f, ok := i.(map[interface{}]interface{}); ok {
+					if l, ok := f["location"].(string); ok {
+						out_args.File.SecondaryFiles = append(out_args.File.SecondaryFiles, JobFile{Location: l, Dir: f["class"] == "Directory"})
+					}
+				}
+			}
+		}
+	case "Directory":
+		base, ok := value.(map[interface{}]interface{})
+		if !ok || base["class"] != "Directory" {
+			return JobArgument{}, fmt.Errorf("Input '%s' is not a Directory: %s", self.Id, JSValueString(value))
+		}
+		loc, _ := base["location"].(string)
+		out_args.File = &JobFile{Id: self.Id, Location: loc, Dir: true}
+		if loc == "" {
+			out_args.File.Literal = base
+		}
+	case "array":
+		base, ok := value.([]interface{})
+		if !ok {
+			return JobArgument{}, fmt.Errorf("Input '%s' is not an array: %s", self.Id, JSValueString(value))
+		}
+		items := Schema{TypeName: "Any"}
+		if t.Items != nil {
+			items = *t.Items
+		}
+		//a binding on an inline array type applies to every item, items
+		//of an array bound without itemSeparator are listed after it
+		if member && t.Bound {
+			items = items.withBinding(t)
+		} else if !items.Bound && self.Bound && self.ItemSeparator == "" {
+			items = items.withBinding(Schema{Bound: true})
+		}
+		items.Id = ""
+		for _, i := range base {
+			e, err := items.SchemaEvaluate(i)
+			if err != nil {
+				return JobArgument{}, err
+			}
+			out_args.Children = append(out_args.Children, e)
+		}
+		sort.Stable(jobArgArray(out_args.Children))
+	case "record":
+		base, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return JobArgument{}, fmt.Errorf("Input '%s' is not a record: %s", self.Id, JSValueString(value))
+		}
+		for _, f := range t.Fields {
+			v, ok := base[f.Name]
+			if !ok || v == nil {
+				continue
+			}
+			e, err := f.SchemaEvaluate(v)
+			if err != nil {
+				return JobArgument{}, err
+			}
+			e.Id = f.Name
+			out_args.Children = append(out_args.Children, e)
+		}
+		sort.Stable(jobArgArray(out_args.Children))
 	}
 	return out_args, nil
 }
 
+// valueType returns the schema describing value, choosing among the types
+// of a union. member is true when the type is not the schema itself, but
+// an inline definition or a union member with a binding of its own.
+func (self *Schema) valueType(value interface{}) (Schema, bool, error) {
+	if self.TypeName == "array_holder" && len(self.Types) > 0 {
+		t, _, err := self.Types[0].valueType(value)
+		return t, true, err
+	}
+	if self.TypeName != "" {
+		return *self, false, nil
+	}
+	for _, t := range self.Types {
+		if t.TypeName != "null" && t.Validate(value) == nil {
+			o, _, err := t.valueType(value)
+			return o, true, err
+		}
+	}
+	return Schema{}, false, fmt.Errorf("Input '%s': %s does not match any type of %s", self.Id, JSValueString(value), self.typeString())
+}
+
+// withBinding returns the schema with the inputBinding of b
+func (self Schema) withBinding(b Schema) Schema {
+	self.Bound = b.Bound
+	self.Position = b.Position
+	self.Prefix = b.Prefix
+	self.ItemSeparator = b.ItemSeparator
+	self.NoSeparate = b.NoSeparate
+	self.NoShellQuote = b.NoShellQuote
+	self.ValueFrom = b.ValueFrom
+	self.LoadContents = b.LoadContents
+	return self
+}
+
 func (self *CommandInput) Evaluate(inputs JSONDict) (JobArgument, error) {
 	out_arg := JobArgument{}
 
 	if base, ok := inputs[self.Id]; ok {
 		a, err := self.SchemaEvaluate(base)
 		if err != nil {
-			log.Printf("Schema Evaluation Error: %s", err)
+			Debugf("Schema Evaluation Error: %s", err)
 			return JobArgument{}, err
 		}
 		out_arg = a
@@ -287,11 +510,11 @@ func (self *CommandInput) Evaluate(inputs JSONDict) (JobArgument, error) {
 		if self.Default != nil {
 			a, err := self.SchemaEvaluate(*self.Default)
 			if err != nil {
-				log.Printf("Schema Evaluation Error: %s", err)
+				Debugf("Schema Evaluation Error: %s", err)
 				return JobArgument{}, err
 			}
 			out_arg = a
-			log.Printf("Default Eval: %s %#v", self.Id, out_arg)
+			Debugf("Default Eval: %s %#v", self.Id, out_arg)
 		} else if self.IsOptional() {
 			return JobArgument{}, nil
 		} else {
@@ -305,23 +528,15 @@ func (self *CommandOutput) Evaluate(inputs JSONDict) (JobArgument, error) {
 	return JobArgument{
 		Id: self.Id,
 		File: &JobFile{
-			Id:     self.Id,
-			Output: true,
-			Glob:   self.Glob,
+			Id:           self.Id,
+			Output:       true,
+			Glob:         self.Glob,
+			LoadContents: self.LoadContents,
+			OutputEval:   self.OutputEval,
 		},
 	}, nil
 }
 
 func (self *Argument) Evaluate(inputs JSONDict) (JobArgument, error) {
-	if self.Value != nil {
-		return JobArgument{Value: *self.Value, Bound: self.Bound}, nil
-	} else if self.ValueFrom != nil {
-		out := JobArgument{Value: *self.ValueFrom, Bound: self.Bound}
-		if self.Prefix != "" {
-			out.Prefix = self.Prefix
-		}
-		out.Position = self.Position
-		return out, nil
-	}
-	return JobArgument{}, nil
+	return self.SchemaEvaluate(nil)
 }
@@ -0,0 +1,350 @@
+package cwl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseText writes a CWL document to a file and parses it
+func parseText(t *testing.T, text string) (CWLGraph, error) {
+	dir, err := ioutil.TempDir("", "command_line_tool_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tool.cwl")
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Parse(path)
+}
+
+// parseTool parses the CommandLineTool in text
+func parseTool(t *testing.T, text string) CWLDoc {
+	docs, err := parseText(t, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, ok := docs.Elements[docs.Main]; ok {
+		return doc
+	}
+	return docs.Elements["#"+docs.Main]
+}
+
+// toolJob generates the job of a tool for inputs
+func toolJob(t *testing.T, text string, inputs JSONDict) Job {
+	doc := parseTool(t, text)
+	job, err := doc.GenerateJob(doc.GetIDs()[0], doc.NewGraphState(inputs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// toolArgs returns the command line of a tool run with inputs
+func toolArgs(t *testing.T, text string, inputs JSONDict) []string {
+	job := toolJob(t, text, inputs)
+	evaluator := JSEvaluator{Inputs: inputs}
+	identity := func(x interface{}) interface{} { return x }
+	out := []string{}
+	for i := range job.Cmd {
+		s, err := job.Cmd[i].GetArgs(evaluator, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, s...)
+	}
+	return out
+}
+
+func TestArrayBindings(t *testing.T) {
+	args := toolArgs(t, `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: echo
+inputs:
+  filesA:
+    type: string[]
+    inputBinding:
+      prefix: -A
+      position: 1
+  filesB:
+    type:
+      type: array
+      items: string
+      inputBinding:
+        prefix: -B=
+        separate: false
+    inputBinding:
+      position: 2
+  filesC:
+    type: string[]
+    inputBinding:
+      prefix: -C=
+      itemSeparator: ","
+      separate: false
+      position: 4
+outputs: []
+`, JSONDict{
+		"filesA": []interface{}{"one", "two", "three"},
+		"filesB": []interface{}{"four", "five", "six"},
+		"filesC": []interface{}{"seven", "eight", "nine"},
+	})
+	expected := []string{"echo", "-A", "one", "two", "three", "-B=four", "-B=five", "-B=six", "-C=seven,eight,nine"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Command line %q, expected %q", args, expected)
+	}
+}
+
+func TestRecordBindings(t *testing.T) {
+	args := toolArgs(t, `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: echo
+inputs:
+  dependent:
+    type:
+      type: record
+      name: dependent
+      fields:
+        itemB:
+          type: string
+          inputBinding:
+            prefix: -B
+            position: 2
+        itemA:
+          type: string
+          inputBinding:
+            prefix: -A
+            position: 1
+    inputBinding:
+      position: 1
+  exclusive:
+    type:
+      - type: record
+        name: itemC
+        fields:
+          itemC:
+            type: string
+            inputBinding:
+              prefix: -C
+      - type: record
+        name: itemD
+        fields:
+          itemD:
+            type: string
+            inputBinding:
+              prefix: -D
+    inputBinding:
+      position: 2
+outputs: []
+`, JSONDict{
+		"dependent": map[interface{}]interface{}{"itemA": "one", "itemB": "two"},
+		"exclusive": map[interface{}]interface{}{"itemD": "four"},
+	})
+	expected := []string{"echo", "-A", "one", "-B", "two", "-D", "four"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Command line %q, expected %q", args, expected)
+	}
+}
+
+func TestScalarBindings(t *testing.T) {
+	tool := `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: cmd
+arguments:
+  - valueFrom: last
+    position: 10
+  - valueFrom: $(inputs.count * 2)
+    prefix: --double
+    position: 3
+inputs:
+  flag:
+    type: boolean
+    inputBinding: {prefix: --flag, position: 1}
+  quiet:
+    type: boolean
+    inputBinding: {prefix: --quiet, position: 1}
+  b:
+    type: int?
+    inputBinding: {prefix: -b, position: 2}
+  a:
+    type: int?
+    inputBinding: {prefix: -a, position: 2}
+  count:
+    type: int
+    inputBinding: {prefix: "-n=", separate: false, position: 4}
+  unbound: string
+outputs: []
+`
+	args := toolArgs(t, tool, JSONDict{"flag": true, "quiet": false, "a": 1, "b": 2, "count": 5, "unbound": "x"})
+	expected := []string{"cmd", "--flag", "-a", "1", "-b", "2", "--double", "10", "-n=5", "last"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Command line %q, expected %q", args, expected)
+	}
+	args = toolArgs(t, tool, JSONDict{"flag": false, "quiet": false, "count": 1, "unbound": "x"})
+	expected = []string{"cmd", "--double", "2", "-n=1", "last"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Command line without optional inputs %q, expected %q", args, expected)
+	}
+}
+
+func TestNestedArrayBindings(t *testing.T) {
+	args := toolArgs(t, `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: cmd
+inputs:
+  matrix:
+    type:
+      type: array
+      items:
+        type: array
+        items: int
+        inputBinding:
+          prefix: --row
+          itemSeparator: ","
+    inputBinding:
+      position: 1
+outputs: []
+`, JSONDict{"matrix": []interface{}{[]interface{}{1, 2}, []interface{}{3, 4}}})
+	expected := []string{"cmd", "--row", "1,2", "--row", "3,4"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Command line %q, expected %q", args, expected)
+	}
+}
+
+func TestArgumentBindings(t *testing.T) {
+	args := toolArgs(t, `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  InlineJavascriptRequirement: {}
+baseCommand: cmd
+arguments:
+  - plain
+  - $(inputs.name)
+  - valueFrom: $(inputs.name)
+    prefix: --name=
+    separate: false
+    position: 1
+  - valueFrom: $(["a", "b"])
+    prefix: --list
+    itemSeparator: ","
+    position: 2
+  - valueFrom: $(["c", "d"])
+    prefix: --each
+    position: 3
+inputs:
+  name: string
+outputs: []
+`, JSONDict{"name": "x"})
+	expected := []string{"cmd", "plain", "x", "--name=x", "--list", "a,b", "--each", "c", "d"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Command line %q, expected %q", args, expected)
+	}
+}
+
+func TestArgumentPositionExpression(t *testing.T) {
+	if _, err := parseText(t, `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: cmd
+arguments:
+  - valueFrom: x
+    position: $(1)
+inputs: []
+outputs: []
+`); err == nil {
+		t.Error("Argument with an expression position parsed")
+	}
+}
+
+func TestStreamNames(t *testing.T) {
+	tool := `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: cat
+inputs:
+  in: stdin
+outputs:
+  out: stdout
+  err: stderr
+`
+	inputs := JSONDict{"in": map[interface{}]interface{}{"class": "File", "location": "/data/in.txt"}}
+	a := toolJob(t, tool, inputs)
+	b := toolJob(t, tool, inputs)
+	if !a.RandomStdout || !a.RandomStderr {
+		t.Error("Generated stream names not flagged")
+	}
+	if len(a.Stdout) != 40 || a.Stdout == a.Stderr || a.Stdout == b.Stdout {
+		t.Errorf("Stream names %s %s and %s are not unique", a.Stdout, a.Stderr, b.Stdout)
+	}
+	if a.Stdin != "$(inputs.in.path)" || a.Inputs["in"].TypeName != "File" {
+		t.Errorf("stdin input read from %q as %s", a.Stdin, a.Inputs["in"].TypeName)
+	}
+
+	named := toolJob(t, tool+"stdout: out.txt\n", inputs)
+	if named.Stdout != "out.txt" || named.RandomStdout {
+		t.Errorf("Declared stdout replaced by %s", named.Stdout)
+	}
+}
+
+func TestStdinConflict(t *testing.T) {
+	if _, err := parseText(t, `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: cat
+stdin: other.txt
+inputs:
+  in: stdin
+outputs: []
+`); err == nil {
+		t.Error("Tool with a stdin input and stdin parsed")
+	}
+}
+
+func TestImageName(t *testing.T) {
+	if n := (DockerRequirement{DockerPull: "debian:9", DockerImageId: "mine"}).ImageName(); n != "mine" {
+		t.Errorf("Image %s, expected the dockerImageId", n)
+	}
+	if n := (DockerRequirement{DockerPull: "debian:9"}).ImageName(); n != "debian:9" {
+		t.Errorf("Image %s, expected the dockerPull", n)
+	}
+	a := DockerRequirement{DockerFile: "FROM debian:9"}.ImageName()
+	b := DockerRequirement{DockerFile: "FROM debian:10"}.ImageName()
+	if a != (DockerRequirement{DockerFile: "FROM debian:9"}).ImageName() || a == b || !strings.HasPrefix(a, "cwl-go-") {
+		t.Errorf("Derived image names %s and %s", a, b)
+	}
+}
+
+func TestDockerRequirement(t *testing.T) {
+	tool := func(req string) string {
+		return `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  DockerRequirement:
+` + req + `baseCommand: "true"
+inputs: []
+outputs: []
+`
+	}
+	job := toolJob(t, tool("    dockerLoad: image.tar\n    dockerOutputDirectory: /out\n"), JSONDict{})
+	if !strings.HasSuffix(job.Docker.DockerLoad, "/image.tar") || !filepath.IsAbs(strings.TrimPrefix(job.Docker.DockerLoad, "file://")) {
+		t.Errorf("dockerLoad not resolved next to the document: %s", job.Docker.DockerLoad)
+	}
+	if job.DockerImage != job.Docker.ImageName() || job.Docker.DockerOutputDirectory != "/out" {
+		t.Errorf("Job image %s, output directory %s", job.DockerImage, job.Docker.DockerOutputDirectory)
+	}
+	job = toolJob(t, tool("    dockerImport: https://example.com/image.tar\n"), JSONDict{})
+	if job.Docker.DockerImport != "https://example.com/image.tar" {
+		t.Errorf("dockerImport URL changed to %s", job.Docker.DockerImport)
+	}
+
+	for _, req := range []string{
+		"    dockerOutputDirectory: /out\n",
+		"    dockerPull: debian:9\n    dockerOutputDirectory: out\n",
+		"    dockerPull: [debian]\n",
+	} {
+		if _, err := parseText(t, tool(req)); err == nil {
+			t.Errorf("DockerRequirement %q parsed", req)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package cwl_engine
+
+import (
+	"crypto/sha1"
+	"cwl"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const CACHE_FILE = "cwl.cache.json"
+
+// CACHE_VERSION is part of every key, it changes when entries stored by
+// earlier versions can't be trusted. Version 2 drops the entries of docker
+// jobs that failed but were recorded as successful, version 3 adds the
+// output bindings to the key.
+const CACHE_VERSION = 3
+
+// JobCache stores the outputs of finished command line jobs under Dir,
+// keyed by a hash of everything that determines what the job produces.
+type JobCache struct {
+	Dir string
+}
+
+func NewJobCache(dir string) (*JobCache, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create cache dir: %s", err)
+	}
+	return &JobCache{Dir: dir}, nil
+}
+
+// Key hashes the evaluated command line, the DockerRequirement, std stream
+// names, the checksums of every input file, the files staged in the host
+// directories in staged, the EnvVarRequirement variables, resources and
+// network access, and everything that decides how outputs are collected:
+// the output bindings and schemas, success codes and listing depth.
+func (self *JobCache) Key(job cwl.Job, cmd_args []string, stdout, stderr, stdin string, inputs cwl.JSONDict, options ProcessOptions, staged []string) (string, error) {
+	checksums := []string{}
+	for _, loc := range fileLocations(inputs) {
+		c, err := checksumPath(loc)
+		if err != nil {
+			return "", fmt.Errorf("Unable to checksum %s: %s", loc, err)
+		}
+		checksums = append(checksums, c)
+	}
+	sort.Strings(checksums)
+	stagedChecksums := []string{}
+	for _, dir := range staged {
+		c := ""
+		if _, err := os.Stat(dir); err == nil {
+			if c, err = checksumPath(dir); err != nil {
+				return "", fmt.Errorf("Unable to checksum %s: %s", dir, err)
+			}
+		}
+		stagedChecksums = append(stagedChecksums, c)
+	}
+	//HOME and TMPDIR are the job's own directories, only variables the
+	//tool sets determine its result
+	env := map[string]string{}
+	for k := range job.Env {
+		env[k] = options.Env[k]
+	}
+	//generated stream names differ on every run
+	if job.RandomStdout {
+		stdout = ""
+	}
+	if job.RandomStderr {
+		stderr = ""
+	}
+	//output defaults never apply, leaving them out keeps the schemas
+	//marshallable
+	outputs := map[string]interface{}{}
+	for k, v := range job.Outputs {
+		v.Default = nil
+		outputs[k] = v
+	}
+	bindings := map[string]interface{}{}
+	for _, f := range job.GetFiles() {
+		if f.Output {
+			bindings[f.Id] = map[string]interface{}{"glob": f.Glob, "outputEval": f.OutputEval, "loadContents": f.LoadContents}
+		}
+	}
+	key := map[string]interface{}{
+		"version":       CACHE_VERSION,
+		"cmd":           cmd_args,
+		"dockerImage":   job.DockerImage,
+		"docker":        job.Docker,
+		"stdout":        stdout,
+		"stderr":        stderr,
+		"stdin":         stdin,
+		"inputs":        checksums,
+		"staged":        stagedChecksums,
+		"env":           env,
+		"resources":     options.Resources,
+		"networkAccess": options.NetworkAccess,
+		"outputs":       outputs,
+		"bindings":      bindings,
+		"successCodes":  job.SuccessCodes,
+		"loadListing":   job.LoadListing,
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(data)), nil
+}
+
+func (self *JobCache) entryDir(key string) string {
+	return filepath.Join(self.Dir, key)
+}
+
+// Get returns the outputs stored for key, if the entry exists
+func (self *JobCache) Get(key string) (cwl.JSONDict, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(self.entryDir(key), CACHE_FILE))
+	if err != nil {
+		return nil, false
+	}
+	doc := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		cwl.Warnf("Bad cache entry %s: %s", key, err)
+		return nil, false
+	}
+	out := cwl.JSONDict(doc)
+	if missing := missingFiles(out); len(missing) > 0 {
+		cwl.Debugf("Cache entry %s missing files %s", key, missing)
+		return nil, false
+	}
+	return out, true
+}
+
+// Put copies the output files of a job into the cache and records the
+// outputs, with locations rewritten to point into the cache entry.
+func (self *JobCache) Put(key string, outputs cwl.JSONDict) error {
+	tmpDir, err := ioutil.TempDir(self.Dir, "tmp_")
+	if err != nil {
+		return err
+	}
+	stored := cwl.JSONDict{}
+	count := 0
+	for k, v := range outputs {
+		o, err := cacheCopy(v, tmpDir, self.entryDir(key), &count)
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+		stored[k] = o
+	}
+	data, err := json.Marshal(stored.Normalize())
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, CACHE_FILE), data, 0644); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	os.RemoveAll(self.entryDir(key))
+	return os.Rename(tmpDir, self.entryDir(key))
+}
+
+// cacheCopy copies the files referenced by an output value into numbered
+// subdirectories of tmpDir, returning the value with locations pointing
+// into entryDir, which tmpDir is renamed to once the entry is complete.
+func cacheCopy(x interface{}, tmpDir string, entryDir string, count *int) (interface{}, error) {
+	if base, ok := x.(cwl.JSONDict); ok {
+		x = map[interface{}]interface{}(base)
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if class, ok := base["class"]; ok && (class == "File" || class == "Directory") {
+			dir := fmt.Sprintf("%d", *count)
+			*count += 1
+			return cacheCopyFile(base, tmpDir, entryDir, dir, "")
+		}
+		out := map[interface{}]interface{}{}
+		for k, v := range base {
+			o, err := cacheCopy(v, tmpDir, entryDir, count)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = o
+		}
+		return out, nil
+	} else if base, ok := x.([]interface{}); ok {
+		out := []interface{}{}
+		for _, v := range base {
+			o, err := cacheCopy(v, tmpDir, entryDir, count)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, o)
+		}
+		return out, nil
+	}
+	return x, nil
+}
+
+// cacheCopyFile copies a File or Directory to subdirectory dir of the
+// entry. Its secondary files are copied to the same directory, where they
+// keep their place relative to primaryDir, the directory of the primary
+// file. The entries of a Directory's listing are copied with it, only
+// their locations change.
+func cacheCopyFile(base map[interface{}]interface{}, tmpDir string, entryDir string, dir string, primaryDir string) (map[interface{}]interface{}, error) {
+	out := map[interface{}]interface{}{}
+	for k, v := range base {
+		out[k] = v
+	}
+	loc, ok := base["location"].(string)
+	if !ok {
+		return out, nil
+	}
+	loc = strings.TrimPrefix(loc, "file://")
+	if primaryDir == "" {
+		primaryDir = filepath.Dir(loc)
+	}
+	rel := filepath.Base(loc)
+	if r, err := filepath.Rel(primaryDir, loc); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	rel = filepath.Join(dir, rel)
+	if err := copyPath(loc, filepath.Join(tmpDir, rel)); err != nil {
+		return nil, err
+	}
+	newLoc := filepath.Join(entryDir, rel)
+	out["location"] = newLoc
+	out["path"] = newLoc
+	out["dirname"] = filepath.Dir(newLoc)
+	if listing, ok := base["listing"]; ok {
+		out["listing"] = relocate(listing, loc, newLoc)
+	}
+	if sf, ok := base["secondaryFiles"].([]interface{}); ok {
+		files := []interface{}{}
+		for _, i := range sf {
+			if s, ok := i.(cwl.JSONDict); ok {
+				i = map[interface{}]interface{}(s)
+			}
+			if s, ok := i.(map[interface{}]interface{}); ok {
+				o, err := cacheCopyFile(s, tmpDir, entryDir, dir, primaryDir)
+				if err != nil {
+					return nil, err
+				}
+				i = o
+			}
+			files = append(files, i)
+		}
+		out["secondaryFiles"] = files
+	}
+	return out, nil
+}
+
+func fileLocations(x interface{}) []string {
+	out := []string{}
+	if base, ok := x.(cwl.JSONDict); ok {
+		x = map[interface{}]interface{}(base)
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if class, ok := base["class"]; ok && (class == "File" || class == "Directory") {
+			if loc, ok := base["location"].(string); ok {
+				out = append(out, strings.TrimPrefix(loc, "file://"))
+			}
+		}
+		for _, v := range base {
+			out = append(out, fileLocations(v)...)
+		}
+	} else if base, ok := x.([]interface{}); ok {
+		for _, v := range base {
+			out = append(out, fileLocations(v)...)
+		}
+	}
+	return out
+}
+
+// checksumPath hashes a file, or every file under a directory along with
+// its relative path
+func checksumPath(path string) (string, error) {
+	hasher := sha1.New()
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(path, p)
+		io.WriteString(hasher, rel)
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(hasher, file)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha1$%x", hasher.Sum(nil)), nil
+}
+
+func copyPath(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(src, p)
+			target := filepath.Join(dst, rel)
+			if info.IsDir() {
+				return os.MkdirAll(target, info.Mode()|0700)
+			}
+			return copyFile(p, target, info.Mode())
+		})
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return copyFile(src, dst, info.Mode())
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,181 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func envTool(value string) string {
+	return `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  EnvVarRequirement:
+    envDef:
+      FOO: "` + value + `"
+baseCommand: [sh, -c, "echo $FOO"]
+inputs: []
+outputs:
+  out:
+    type: string
+    outputBinding:
+      glob: out.txt
+      loadContents: true
+      outputEval: $(self[0].contents)
+stdout: out.txt
+`
+}
+
+// runCached runs tool with the cache in cacheDir, returning its output and
+// whether it came from the cache
+func runCached(t *testing.T, dir, cacheDir, tool string) (string, bool) {
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": tool}, "tool.cwl")
+	exec, err := NewExecutor(Config{TmpdirPrefix: dir, TmpOutdirPrefix: dir, CacheDir: cacheDir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := false
+	exec.AddObserver(ObserverFunc(func(e Event) {
+		if s, ok := e.(StepSkippedEvent); ok && s.Reason == "cached" {
+			cached = true
+		}
+	}))
+	out, _, err := exec.Run(context.Background(), doc, cwl.JSONDict{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := out["out"].(string)
+	return s, cached
+}
+
+func TestCacheKeyEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+
+	if out, cached := runCached(t, dir, cacheDir, envTool("a")); cached || out != "a\n" {
+		t.Fatalf("First run: %q cached %v", out, cached)
+	}
+	if out, cached := runCached(t, dir, cacheDir, envTool("a")); !cached || out != "a\n" {
+		t.Errorf("Same job: %q cached %v", out, cached)
+	}
+	if out, cached := runCached(t, dir, cacheDir, envTool("b")); cached || out != "b\n" {
+		t.Errorf("Changed envDef: %q cached %v", out, cached)
+	}
+}
+
+func TestCachePutListingAndSecondaryFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewJobCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	work := filepath.Join(dir, "work")
+	for _, f := range []string{"out/a.txt", "reads.bam", "reads.bam.bai"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(work, f)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(work, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outputs := cwl.JSONDict{
+		"dir": map[interface{}]interface{}{
+			"class":    "Directory",
+			"location": filepath.Join(work, "out"),
+			"listing": []interface{}{
+				map[interface{}]interface{}{"class": "File", "location": filepath.Join(work, "out/a.txt")},
+			},
+		},
+		"bam": map[interface{}]interface{}{
+			"class":    "File",
+			"location": filepath.Join(work, "reads.bam"),
+			"secondaryFiles": []interface{}{
+				map[interface{}]interface{}{"class": "File", "location": filepath.Join(work, "reads.bam.bai")},
+			},
+		},
+	}
+	if err := cache.Put("key", outputs); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(work)
+
+	out, ok := cache.Get("key")
+	if !ok {
+		t.Fatal("Entry with listing and secondaryFiles not found")
+	}
+	listing := out["dir"].(map[interface{}]interface{})["listing"].([]interface{})
+	a := listing[0].(map[interface{}]interface{})["location"].(string)
+	if data, err := ioutil.ReadFile(a); err != nil || string(data) != "out/a.txt" {
+		t.Errorf("Listing entry %s: %q %v", a, data, err)
+	}
+	bam := out["bam"].(map[interface{}]interface{})
+	bai := bam["secondaryFiles"].([]interface{})[0].(map[interface{}]interface{})["location"].(string)
+	if bai != bam["location"].(string)+".bai" {
+		t.Errorf("Secondary file %s not next to %s", bai, bam["location"])
+	}
+}
+
+func outputTool(outputEval string) string {
+	return `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  InlineJavascriptRequirement: {}
+baseCommand: [echo, a]
+inputs: []
+outputs:
+  out:
+    type: string
+    outputBinding:
+      glob: out.txt
+      loadContents: true
+      outputEval: ` + outputEval + `
+stdout: out.txt
+`
+}
+
+func TestCacheKeyOutputBindings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+
+	if out, cached := runCached(t, dir, cacheDir, outputTool("$(self[0].contents)")); cached || out != "a\n" {
+		t.Fatalf("First run: %q cached %v", out, cached)
+	}
+	if out, cached := runCached(t, dir, cacheDir, outputTool("$(self[0].contents + 'b')")); cached || out != "a\nb" {
+		t.Errorf("Changed outputEval: %q cached %v", out, cached)
+	}
+}
+
+func TestCacheHitValidated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+
+	runCached(t, dir, cacheDir, envTool("a"))
+	entries, err := filepath.Glob(filepath.Join(cacheDir, "*", CACHE_FILE))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Cache entries %v %v", entries, err)
+	}
+	//a string output stored as a number
+	if err := ioutil.WriteFile(entries[0], []byte(`{"out": 5}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, cached := runCached(t, dir, cacheDir, envTool("a")); cached || out != "a\n" {
+		t.Errorf("Invalid entry: %q cached %v", out, cached)
+	}
+}
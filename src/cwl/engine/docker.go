@@ -1,128 +1,290 @@
 package cwl_engine
 
 import (
+	"bytes"
 	"cwl"
 	"fmt"
-	"gopkg.in/yaml.v2"
+	"io"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 )
 
-func NewDockerRunner(config Config) (JobRunner, error) {
+// DOCKER_WORKDIR is where the output directory is mounted in containers
+// unless the DockerRequirement names a dockerOutputDirectory, the job's
+// temporary directory is mounted at DOCKER_TMPDIR
+const (
+	DOCKER_WORKDIR = "/var/run/cwl-go"
+	DOCKER_TMPDIR  = "/tmp"
+)
+
+func NewDockerRunner(config Config, docker cwl.DockerRequirement) (JobRunner, error) {
 	workdir, err := ioutil.TempDir(config.TmpdirPrefix, "cwlwork_")
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create working dir")
 	}
-	return DockerRunner{Config: config, hostWorkDir: workdir, fileMap: map[string]string{}, fileMapRev: map[string]string{}}, nil
+	tmpdir, err := ioutil.TempDir(config.TmpdirPrefix, "cwltmp_")
+	if err != nil {
+		os.RemoveAll(workdir)
+		return nil, fmt.Errorf("Unable to create temp dir")
+	}
+	return DockerRunner{Config: config, Docker: docker, hostWorkDir: workdir, hostTmpDir: tmpdir, fileMap: map[string]string{}, fileMapRev: map[string]string{}, dirMap: map[string]string{}}, nil
 }
 
 type DockerRunner struct {
 	Config      Config
+	Docker      cwl.DockerRequirement
 	hostWorkDir string
+	hostTmpDir  string
 	fileMap     map[string]string
 	fileMapRev  map[string]string
+	//files from one host directory share a container directory, so
+	//secondary files stay next to their primary file
+	dirMap map[string]string
+}
+
+func (self DockerRunner) GetTmpDirPath() string {
+	return DOCKER_TMPDIR
 }
 
 func (self DockerRunner) GetWorkDirPath() string {
-	return "/var/run/cwl-go"
+	return dockerWorkDir(self.Docker)
+}
+
+func dockerWorkDir(docker cwl.DockerRequirement) string {
+	if docker.DockerOutputDirectory != "" {
+		return docker.DockerOutputDirectory
+	}
+	return DOCKER_WORKDIR
+}
+
+// prepImage makes sure image is present, building, loading, importing or
+// pulling it as the DockerRequirement says
+func (self DockerRunner) prepImage(image string) error {
+	if exec.Command("docker", "image", "inspect", image).Run() == nil {
+		return nil
+	}
+	cwl.Infof("Preparing image %s", image)
+	switch {
+	case self.Docker.DockerFile != "":
+		dir, err := ioutil.TempDir(self.Config.TmpdirPrefix, "cwlbuild_")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(self.Docker.DockerFile), 0644); err != nil {
+			return err
+		}
+		_, err = dockerCommand(nil, "build", "-t", image, dir)
+		return err
+	case self.Docker.DockerLoad != "":
+		src, err := openImageSource(self.Docker.DockerLoad)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		out, err := dockerCommand(src, "load")
+		if err != nil {
+			return err
+		}
+		loaded := loadedImage(out)
+		if loaded == "" {
+			return fmt.Errorf("No image loaded from %s", self.Docker.DockerLoad)
+		}
+		if loaded != image {
+			_, err = dockerCommand(nil, "tag", loaded, image)
+		}
+		return err
+	case self.Docker.DockerImport != "":
+		_, err := dockerCommand(nil, "import", self.Docker.DockerImport, image)
+		return err
+	case self.Docker.DockerPull != "":
+		if _, err := dockerCommand(nil, "pull", self.Docker.DockerPull); err != nil {
+			return err
+		}
+		if self.Docker.DockerPull != image {
+			_, err := dockerCommand(nil, "tag", self.Docker.DockerPull, image)
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("Docker image %s not found", image)
+}
+
+// dockerCommand runs the docker client, returning its output
+func dockerCommand(stdin io.Reader, args ...string) (string, error) {
+	cwl.Debugf("Runner docker %s", strings.Join(args, " "))
+	cmd := exec.Command("docker", args...)
+	cmd.Stdin = stdin
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("docker %s failed: %s %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
+
+// openImageSource opens the image tar of a dockerLoad, a path or an HTTP URL
+func openImageSource(src string) (io.ReadCloser, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := http.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Unable to get %s: %s", src, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	return os.Open(src)
+}
+
+// loadedImage finds the image docker load reports, by name or by ID when
+// the tar carried no tags
+func loadedImage(out string) string {
+	image := ""
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Loaded image: ") {
+			image = strings.TrimPrefix(line, "Loaded image: ")
+		} else if strings.HasPrefix(line, "Loaded image ID: ") {
+			image = strings.TrimPrefix(line, "Loaded image ID: ")
+		}
+	}
+	return image
 }
 
 func (self DockerRunner) LocationToPath(location string) string {
 	if out, ok := self.fileMapRev[location]; ok {
 		return out
 	}
-	base := filepath.Base(location)
-	out := fmt.Sprintf("/var/run/cwlinput/%d/%s", len(self.fileMap), base)
+	//entries of a Directory that is already mounted are found inside it
+	for host, mapped := range self.fileMapRev {
+		if rel, err := filepath.Rel(host, location); err == nil && !strings.HasPrefix(rel, "..") && isDir(host) {
+			out := filepath.Join(mapped, rel)
+			self.fileMap[out] = location
+			self.fileMapRev[location] = out
+			return out
+		}
+	}
+	dir, ok := self.dirMap[filepath.Dir(location)]
+	if !ok {
+		dir = fmt.Sprintf("/var/run/cwlinput/%d", len(self.dirMap))
+		self.dirMap[filepath.Dir(location)] = dir
+	}
+	out := filepath.Join(dir, filepath.Base(location))
 	self.fileMap[out] = location
 	self.fileMapRev[location] = out
-	log.Printf("Translating: %s to %s", location, out)
+	cwl.Debugf("Translating: %s to %s", location, out)
 	return out
 }
 
-func (self DockerRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin, dockerImage string) (cwl.JSONDict, error) {
+// dockerBinds mounts the output and temporary directories writable and
+// the input files read only
+func dockerBinds(inputs cwl.JSONDict, fileMap map[string]string, hostWorkDir, workdir, hostTmpDir string) []string {
+	binds := []string{fmt.Sprintf("%s:%s", hostWorkDir, workdir), fmt.Sprintf("%s:%s", hostTmpDir, DOCKER_TMPDIR)}
+	for _, n := range inputs.GetFilePaths() {
+		binds = append(binds, fmt.Sprintf("%s:%s:ro", fileMap[n], n))
+	}
+	return binds
+}
+
+// dockerUser runs containers as the invoking user, so the files they
+// write can be handled without root
+func dockerUser() string {
+	return fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+}
+
+// hostPath returns the host path of a path inside a container, given the
+// mounted inputs and the work directory mount
+func hostPath(p string, fileMap map[string]string, workdir, hostWorkDir string) string {
+	if out, ok := fileMap[p]; ok {
+		return out
+	}
+	if rel, err := filepath.Rel(workdir, p); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.Join(hostWorkDir, rel)
+	}
+	return p
+}
 
-	binds := []string{fmt.Sprintf("%s:%s", self.hostWorkDir, workdir)}
+func (self DockerRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin string, options ProcessOptions) (cwl.JSONDict, error) {
 
-	log.Printf("Docker files: %s", inputs.GetFilePaths())
+	cwl.Debugf("Docker files: %s", inputs.GetFilePaths())
+	binds := dockerBinds(inputs, self.fileMap, self.hostWorkDir, workdir, self.hostTmpDir)
+	cwl.Debugf("Docker Binds: %s", binds)
 
-	for _, n := range inputs.GetFilePaths() {
-		binds = append(binds, fmt.Sprintf("%s:%s", self.fileMap[n], n))
+	if err := self.prepImage(options.DockerImage); err != nil {
+		return cwl.JSONDict{}, err
 	}
-	log.Printf("Docker Binds: %s", binds)
 
 	resFile := self.hostWorkDir + ".result"
+	name := containerName(self.hostWorkDir)
 	go func(callback func(int)) {
 
-		args := []string{"run", "--rm", "-i", "-w", workdir}
+		args := []string{"run", "--rm", "-i", "--name", name, "--user", dockerUser(), "-w", workdir}
 
+		for _, e := range environList(hostEnvironment(self.Config, true), options.Env) {
+			args = append(args, "-e", e)
+		}
+		if !options.NetworkAccess {
+			args = append(args, "--network", "none")
+		}
+		if options.Resources.Limited {
+			args = append(args, fmt.Sprintf("--cpus=%g", options.Resources.CoresLimit), fmt.Sprintf("--memory=%dm", options.Resources.RamLimit))
+		}
 		for _, i := range binds {
 			args = append(args, "-v", i)
 		}
-		args = append(args, dockerImage)
+		args = append(args, options.DockerImage)
 		args = append(args, cmd_args...)
-		log.Printf("Runner docker %s", strings.Join(args, " "))
+		cwl.Debugf("Runner docker %s", strings.Join(args, " "))
 
 		cmd := exec.Command("docker", args...)
 
-		if stdout != "" {
-			var err error
-			cmd.Stdout, err = os.Create(filepath.Join(self.hostWorkDir, stdout))
-			if err != nil {
-				callback(1)
-				return
-			}
-		}
-		if stderr != "" {
-			var err error
-			cmd.Stderr, err = os.Create(filepath.Join(self.hostWorkDir, stderr))
-			if err != nil {
-				callback(1)
-				return
-			}
-		}
+		hostStdin := ""
 		if stdin != "" {
-			log.Printf("Stdin %s to %s", stdin, self.fileMap[stdin])
-			var err error
-			cmd.Stdin, err = os.Open(self.fileMap[stdin])
-			if err != nil {
-				callback(1)
-				return
-			}
-		}
-		cmd_err := cmd.Run()
-
-		exitStatus := 0
-		if exiterr, ok := cmd_err.(*exec.ExitError); ok {
-			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				exitStatus = status.ExitStatus()
-				log.Printf("Exit Status: %d", exitStatus)
-			}
-		} else {
-			log.Printf("cmd.Run: %v", cmd_err)
-		}
-		callback(0)
+			hostStdin = hostPath(stdin, self.fileMap, workdir, self.hostWorkDir)
+			cwl.Debugf("Stdin %s to %s", stdin, hostStdin)
+		}
+		files, err := openStreams(cmd, self.hostWorkDir, stdout, stderr, hostStdin)
+		defer closeFiles(files)
+		if err != nil {
+			cwl.LogErrorf("Job streams: %s", err)
+			callback(1)
+			return
+		}
+		callback(dockerExitStatus(cmd.Run()))
 	}(func(exitStatus int) {
 		ioutil.WriteFile(resFile, []byte(fmt.Sprintf("%d", exitStatus)), 0600)
 	})
-	return cwl.JSONDict{"resFile": resFile}, nil
+	return cwl.JSONDict{"resFile": resFile, "container": name}, nil
 }
 
-func (self DockerRunner) GetOutput(prodData cwl.JSONDict) cwl.JSONDict {
-	out := cwl.JSONDict{}
-	path := filepath.Join(self.hostWorkDir, "cwl.output.json")
-	if _, err := os.Stat(path); err == nil {
-		log.Printf("Found cwl.output.json")
-		data, _ := ioutil.ReadFile(path)
-		err := yaml.Unmarshal(data, &out)
-		log.Printf("Returned: %s = %s %s", data, out, err)
+// dockerExitStatus returns the exit status of docker run, which is the
+// status of the container's command. A docker client that couldn't be run
+// fails the job.
+func dockerExitStatus(cmd_err error) int {
+	if exiterr, ok := cmd_err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			cwl.Debugf("Exit Status: %d", status.ExitStatus())
+			return status.ExitStatus()
+		}
+		return 1
+	} else if cmd_err != nil {
+		cwl.LogErrorf("docker run: %v", cmd_err)
+		return 1
 	}
-	return out
+	return 0
+}
+
+func (self DockerRunner) GetOutput(prodData cwl.JSONDict) (cwl.JSONDict, bool) {
+	return readOutputFile(filepath.Join(self.hostWorkDir, "cwl.output.json"))
 }
 
 func (self DockerRunner) ReadFile(location string) ([]byte, error) {
@@ -135,10 +297,30 @@ func (self DockerRunner) Glob(pattern string) []string {
 }
 
 func (self DockerRunner) ExitCode(procData cwl.JSONDict) (int, bool) {
-	if _, err := os.Stat(procData["resFile"].(string)); err == nil {
-		d, _ := ioutil.ReadFile(procData["resFile"].(string))
-		i, _ := strconv.Atoi(string(d))
-		return i, true
+	return readExitCode(procData["resFile"].(string))
+}
+
+func (self DockerRunner) Kill(procData cwl.JSONDict) error {
+	name, ok := procData["container"].(string)
+	if !ok {
+		return fmt.Errorf("No container to kill")
 	}
-	return 0, false
+	return exec.Command("docker", "kill", name).Run()
+}
+
+// containerName names the container of the job in hostWorkDir, so it can
+// be found again to kill it
+func containerName(hostWorkDir string) string {
+	return "cwl-" + filepath.Base(hostWorkDir)
+}
+
+func (self DockerRunner) GetHostWorkDir() string {
+	return self.hostWorkDir
+}
+
+func (self DockerRunner) Cleanup() error {
+	os.Remove(self.hostWorkDir + ".result")
+	os.RemoveAll(self.hostWorkDir + LITERALS_SUFFIX)
+	os.RemoveAll(self.hostTmpDir)
+	return os.RemoveAll(self.hostWorkDir)
 }
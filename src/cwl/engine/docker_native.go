@@ -1,194 +1,331 @@
 package cwl_engine
 
 import (
+	"archive/tar"
+	"bytes"
 	"cwl"
+	"encoding/json"
 	"fmt"
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"golang.org/x/net/context"
-	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
-func NewNativeDockerRunner(config Config) (JobRunner, error) {
+func NewNativeDockerRunner(config Config, docker cwl.DockerRequirement) (JobRunner, error) {
 	workdir, err := ioutil.TempDir(config.TmpdirPrefix, "cwlwork_")
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create working dir")
 	}
-	return DockerNativeRunner{Config: config, hostWorkDir: workdir, fileMap: map[string]string{}, fileMapRev: map[string]string{}}, nil
+	tmpdir, err := ioutil.TempDir(config.TmpdirPrefix, "cwltmp_")
+	if err != nil {
+		os.RemoveAll(workdir)
+		return nil, fmt.Errorf("Unable to create temp dir")
+	}
+	return DockerNativeRunner{Config: config, Docker: docker, hostWorkDir: workdir, hostTmpDir: tmpdir, fileMap: map[string]string{}, fileMapRev: map[string]string{}, dirMap: map[string]string{}}, nil
 }
 
 type DockerNativeRunner struct {
 	Config      Config
+	Docker      cwl.DockerRequirement
 	hostWorkDir string
+	hostTmpDir  string
 	fileMap     map[string]string
 	fileMapRev  map[string]string
+	//files from one host directory share a container directory, so
+	//secondary files stay next to their primary file
+	dirMap map[string]string
 }
 
 func (self DockerNativeRunner) getClient() (*client.Client, error) {
 	client, err := client.NewEnvClient()
 	if err != nil {
-		log.Printf("Docker Error\n")
+		cwl.LogErrorf("Docker Error: %s", err)
 		return nil, err
 	}
 	return client, nil
 }
 
-func (self DockerNativeRunner) prepImage(imageName string) error {
+// prepImage makes sure image is present, building, loading, importing or
+// pulling it as the DockerRequirement says
+func (self DockerNativeRunner) prepImage(image string) error {
 	client, err := self.getClient()
 	if err != nil {
 		return err
 	}
+	ctx := context.Background()
 	filt := filters.NewArgs()
-	filt.Add("image.name", imageName)
-	list, err := client.ImageList(context.Background(), types.ImageListOptions{Filters:filt})
-
-	if err != nil || len(list) == 0 {
-		log.Printf("Image %s not found: %s", imageName, err)
-		pull_opt := types.ImagePullOptions{}
-		r, err := client.ImagePull(context.Background(), imageName, pull_opt)
+	filt.Add("image.name", image)
+	list, err := client.ImageList(ctx, types.ImageListOptions{Filters: filt})
+	if err == nil && len(list) > 0 {
+		return nil
+	}
+	cwl.Debugf("Image %s not found: %s", image, err)
+	cwl.Infof("Preparing image %s", image)
+	switch {
+	case self.Docker.DockerFile != "":
+		resp, err := client.ImageBuild(ctx, dockerfileContext(self.Docker.DockerFile), types.ImageBuildOptions{Tags: []string{image}, Remove: true})
 		if err != nil {
-			log.Printf("Image not pulled: %s", err)
 			return err
 		}
-		for {
-			l := make([]byte, 1000)
-			_, e := r.Read(l)
-			if e == io.EOF {
-				break
-			}
-			log.Printf("%s", l)
+		defer resp.Body.Close()
+		_, err = readDockerStream(resp.Body)
+		return err
+	case self.Docker.DockerLoad != "":
+		src, err := openImageSource(self.Docker.DockerLoad)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		resp, err := client.ImageLoad(ctx, src, true)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		out, err := readDockerStream(resp.Body)
+		if err != nil {
+			return err
+		}
+		loaded := loadedImage(out)
+		if loaded == "" {
+			return fmt.Errorf("No image loaded from %s", self.Docker.DockerLoad)
+		}
+		if loaded != image {
+			err = client.ImageTag(ctx, loaded, image)
+		}
+		return err
+	case self.Docker.DockerImport != "":
+		src, err := openImageSource(self.Docker.DockerImport)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		r, err := client.ImageImport(ctx, types.ImageImportSource{Source: src, SourceName: "-"}, image, types.ImageImportOptions{})
+		if err != nil {
+			return err
 		}
+		defer r.Close()
+		_, err = readDockerStream(r)
+		return err
+	case self.Docker.DockerPull != "":
+		r, err := client.ImagePull(ctx, self.Docker.DockerPull, types.ImagePullOptions{})
+		if err != nil {
+			cwl.LogErrorf("Image not pulled: %s", err)
+			return err
+		}
+		_, err = readDockerStream(r)
 		r.Close()
-		log.Printf("Image %s Pulled", imageName)
+		if err != nil {
+			return err
+		}
+		cwl.Infof("Image %s Pulled", self.Docker.DockerPull)
+		if self.Docker.DockerPull != image {
+			return client.ImageTag(ctx, self.Docker.DockerPull, image)
+		}
+		return nil
+	}
+	return fmt.Errorf("Docker image %s not found", image)
+}
+
+// readDockerStream reads the JSON messages of a docker API response,
+// returning the text they carry or the error one of them reports
+func readDockerStream(r io.Reader) (string, error) {
+	out := ""
+	dec := json.NewDecoder(r)
+	for {
+		msg := struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}{}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return out, err
+		}
+		if msg.Error != "" {
+			return out, fmt.Errorf("%s", msg.Error)
+		}
+		out += msg.Stream
 	}
-	return nil
+}
+
+// dockerfileContext is a build context holding only the Dockerfile
+func dockerfileContext(dockerfile string) io.Reader {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len(dockerfile))})
+	tw.Write([]byte(dockerfile))
+	tw.Close()
+	return buf
+}
+
+func (self DockerNativeRunner) GetTmpDirPath() string {
+	return DOCKER_TMPDIR
 }
 
 func (self DockerNativeRunner) GetWorkDirPath() string {
-	return "/var/run/cwl-go"
+	return dockerWorkDir(self.Docker)
 }
 
 func (self DockerNativeRunner) LocationToPath(location string) string {
 	if out, ok := self.fileMapRev[location]; ok {
 		return out
 	}
-	base := filepath.Base(location)
-	out := fmt.Sprintf("/var/run/cwlinput/%d/%s", len(self.fileMap), base)
+	//entries of a Directory that is already mounted are found inside it
+	for host, mapped := range self.fileMapRev {
+		if rel, err := filepath.Rel(host, location); err == nil && !strings.HasPrefix(rel, "..") && isDir(host) {
+			out := filepath.Join(mapped, rel)
+			self.fileMap[out] = location
+			self.fileMapRev[location] = out
+			return out
+		}
+	}
+	dir, ok := self.dirMap[filepath.Dir(location)]
+	if !ok {
+		dir = fmt.Sprintf("/var/run/cwlinput/%d", len(self.dirMap))
+		self.dirMap[filepath.Dir(location)] = dir
+	}
+	out := filepath.Join(dir, filepath.Base(location))
 	self.fileMap[out] = location
 	self.fileMapRev[location] = out
-	log.Printf("Translating: %s to %s", location, out)
+	cwl.Debugf("Translating: %s to %s", location, out)
 	return out
 }
 
-func (self DockerNativeRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin, dockerImage string) (cwl.JSONDict, error) {
+// containerResources converts the limits of a job to container resources
+func containerResources(resources JobResources) container.Resources {
+	if !resources.Limited {
+		return container.Resources{}
+	}
+	return container.Resources{NanoCPUs: int64(resources.CoresLimit * 1e9), Memory: resources.RamLimit * 1024 * 1024}
+}
 
-	self.prepImage(dockerImage)
+// networkMode isolates containers of jobs without NetworkAccess
+func networkMode(networkAccess bool) container.NetworkMode {
+	if networkAccess {
+		return container.NetworkMode("default")
+	}
+	return container.NetworkMode("none")
+}
+
+func (self DockerNativeRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin string, options ProcessOptions) (cwl.JSONDict, error) {
+
+	if err := self.prepImage(options.DockerImage); err != nil {
+		return cwl.JSONDict{}, err
+	}
 	client, err := self.getClient()
 	if err != nil {
 		return cwl.JSONDict{}, err
 	}
-	binds := []string{fmt.Sprintf("%s:%s", self.hostWorkDir, workdir)}
+	cwl.Debugf("Docker files: %s", inputs.GetFilePaths())
+	binds := dockerBinds(inputs, self.fileMap, self.hostWorkDir, workdir, self.hostTmpDir)
+	cwl.Debugf("Docker Binds: %s", binds)
 
-	log.Printf("Docker files: %s", inputs.GetFilePaths())
-
-	for _, n := range inputs.GetFilePaths() {
-		binds = append(binds, fmt.Sprintf("%s:%s", self.fileMap[n], n))
+	var stdin_file *os.File
+	if stdin != "" {
+		stdin_file, err = os.Open(hostPath(stdin, self.fileMap, workdir, self.hostWorkDir))
+		if err != nil {
+			return cwl.JSONDict{}, err
+		}
 	}
-	log.Printf("Docker Binds: %s", binds)
 
 	container, err := client.ContainerCreate(context.Background(),
-		&container.Config{Cmd: cmd_args, Image: dockerImage, WorkingDir: workdir, Tty: true},
-		&container.HostConfig{Binds: binds},
+		&container.Config{Cmd: cmd_args, Image: options.DockerImage, WorkingDir: workdir, User: dockerUser(),
+			Env:         environList(hostEnvironment(self.Config, true), options.Env),
+			AttachStdin: stdin_file != nil, OpenStdin: stdin_file != nil, StdinOnce: stdin_file != nil},
+		&container.HostConfig{Binds: binds, Resources: containerResources(options.Resources), NetworkMode: networkMode(options.NetworkAccess)},
 		&network.NetworkingConfig{},
 		"",
 	)
 
 	if err != nil {
-		log.Printf("Docker run Error: %s", err)
+		cwl.LogErrorf("Docker run Error: %s", err)
 		return cwl.JSONDict{}, err
 	}
 
-	log.Printf("Starting Docker %s (mount: %s): %s", container.ID, strings.Join(binds, ","), strings.Join(cmd_args, " "))
+	if stdin_file != nil {
+		hijack, err := client.ContainerAttach(context.Background(), container.ID, types.ContainerAttachOptions{Stream: true, Stdin: true})
+		if err != nil {
+			stdin_file.Close()
+			return cwl.JSONDict{}, err
+		}
+		go func() {
+			io.Copy(hijack.Conn, stdin_file)
+			stdin_file.Close()
+			hijack.CloseWrite()
+			hijack.Close()
+		}()
+	}
+
+	cwl.Debugf("Starting Docker %s (mount: %s): %s", container.ID, strings.Join(binds, ","), strings.Join(cmd_args, " "))
 	err = client.ContainerStart(context.Background(), container.ID, types.ContainerStartOptions{})
 
 	if err != nil {
-		log.Printf("Docker run Error: %s", err)
+		cwl.LogErrorf("Docker run Error: %s", err)
 		return cwl.JSONDict{}, err
 	}
 
 	resFile := self.hostWorkDir + ".result"
 	go func() {
-		client, err := self.getClient()
-		if err != nil {
-			log.Printf("Docker client error: %s", err)
-		}
-		log.Printf("Attaching Container: %s", container.ID)
-		exit_code, err := client.ContainerWait(context.Background(), container.ID)
-		if err != nil {
-			log.Printf("docker %s error: %s", container.ID, err)
-		} else {
-			log.Printf("docker %s complete: %s", container.ID, exit_code)
-		}
-
-		if stdout != "" {
-			stdout_file, _ := os.Create(filepath.Join(self.hostWorkDir, stdout))
-			stdout_log, _ := client.ContainerLogs(context.Background(), container.ID, types.ContainerLogsOptions{ShowStdout: true})
-			buffer := make([]byte, 10240)
-			for {
-				l, e := stdout_log.Read(buffer)
-				if e == io.EOF {
-					break
+		cwl.Debugf("Attaching Container: %s", container.ID)
+		exit_code := nativeExitStatus(client.ContainerWait(context.Background(), container.ID))
+		cwl.Debugf("docker %s complete: %d", container.ID, exit_code)
+
+		if stdout != "" || stderr != "" {
+			var stdout_file, stderr_file io.Writer = ioutil.Discard, ioutil.Discard
+			files := []*os.File{}
+			if stdout != "" {
+				if f, err := os.Create(filepath.Join(self.hostWorkDir, stdout)); err == nil {
+					files = append(files, f)
+					stdout_file = f
 				}
-				stdout_file.Write(buffer[:l])
 			}
-			stdout_file.Close()
-			stdout_log.Close()
-		}
-
-		if stderr != "" {
-			stderr_file, _ := os.Create(filepath.Join(self.hostWorkDir, stderr))
-			stderr_log, err := client.ContainerLogs(context.Background(), container.ID, types.ContainerLogsOptions{ShowStdout: true}) //types.ContainerLogsOptions{ShowStderr: true, Details: false})
+			if stderr != "" {
+				if f, err := os.Create(filepath.Join(self.hostWorkDir, stderr)); err == nil {
+					files = append(files, f)
+					stderr_file = f
+				}
+			}
+			logs, err := client.ContainerLogs(context.Background(), container.ID, types.ContainerLogsOptions{ShowStdout: stdout != "", ShowStderr: stderr != ""})
 			if err != nil {
-				log.Printf("Read Error: %s", err)
+				cwl.Warnf("Read Error: %s", err)
+			} else {
+				//without a tty the log stream multiplexes stdout and stderr
+				stdcopy.StdCopy(stdout_file, stderr_file, logs)
+				logs.Close()
 			}
-			buffer := make([]byte, 10240)
-			for {
-				l, e := stderr_log.Read(buffer)
-				log.Printf("Logging: %s", buffer)
-				if e == io.EOF {
-					break
-				}
-				stderr_file.Write(buffer[:l])
+			for _, f := range files {
+				f.Close()
 			}
-			stderr_log.Close()
-			stderr_file.Close()
 		}
-		//client.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{RemoveVolumes: true})
+		if err := client.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true}); err != nil {
+			cwl.Warnf("Unable to remove container %s: %s", container.ID, err)
+		}
 		ioutil.WriteFile(resFile, []byte(fmt.Sprintf("%d", exit_code)), 0600)
 	}()
-	return cwl.JSONDict{"resFile": resFile}, nil
+	return cwl.JSONDict{"resFile": resFile, "container": container.ID}, nil
 }
 
-func (self DockerNativeRunner) GetOutput(prodData cwl.JSONDict) cwl.JSONDict {
-	out := cwl.JSONDict{}
-	path := filepath.Join(self.hostWorkDir, "cwl.output.json")
-	if _, err := os.Stat(path); err == nil {
-		log.Printf("Found cwl.output.json")
-		data, _ := ioutil.ReadFile(path)
-		err := yaml.Unmarshal(data, &out)
-		log.Printf("Returned: %s = %s %s", data, out, err)
+// nativeExitStatus returns the status of a container given the result of
+// ContainerWait. A container that couldn't be waited for fails the job.
+func nativeExitStatus(code int64, err error) int {
+	if err != nil {
+		cwl.LogErrorf("Docker wait error: %s", err)
+		return 1
 	}
-	return out
+	return int(code)
+}
+
+func (self DockerNativeRunner) GetOutput(prodData cwl.JSONDict) (cwl.JSONDict, bool) {
+	return readOutputFile(filepath.Join(self.hostWorkDir, "cwl.output.json"))
 }
 
 func (self DockerNativeRunner) ReadFile(path string) ([]byte, error) {
@@ -201,10 +338,28 @@ func (self DockerNativeRunner) Glob(pattern string) []string {
 }
 
 func (self DockerNativeRunner) ExitCode(procData cwl.JSONDict) (int, bool) {
-	if _, err := os.Stat(procData["resFile"].(string)); err == nil {
-		d, _ := ioutil.ReadFile(procData["resFile"].(string))
-		i, _ := strconv.Atoi(string(d))
-		return i, true
+	return readExitCode(procData["resFile"].(string))
+}
+
+func (self DockerNativeRunner) Kill(procData cwl.JSONDict) error {
+	id, ok := procData["container"].(string)
+	if !ok {
+		return fmt.Errorf("No container to kill")
+	}
+	client, err := self.getClient()
+	if err != nil {
+		return err
 	}
-	return 0, false
+	return client.ContainerKill(context.Background(), id, "KILL")
+}
+
+func (self DockerNativeRunner) GetHostWorkDir() string {
+	return self.hostWorkDir
+}
+
+func (self DockerNativeRunner) Cleanup() error {
+	os.Remove(self.hostWorkDir + ".result")
+	os.RemoveAll(self.hostWorkDir + LITERALS_SUFFIX)
+	os.RemoveAll(self.hostTmpDir)
+	return os.RemoveAll(self.hostWorkDir)
 }
@@ -0,0 +1,141 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a docker client that has every image and whose containers exit with 3
+const fakeDocker = `#!/bin/sh
+case "$1" in
+run) exit 3 ;;
+esac
+exit 0
+`
+
+// useDocker puts a docker client running script first in PATH, until the
+// returned function is called
+func useDocker(t *testing.T, dir string, script string) func() {
+	bin := filepath.Join(dir, "bin")
+	os.Mkdir(bin, 0755)
+	if err := ioutil.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	return func() { os.Setenv("PATH", path) }
+}
+
+func TestDockerExitCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer useDocker(t, dir, fakeDocker)()
+
+	runner, err := NewDockerRunner(Config{TmpdirPrefix: dir}, cwl.DockerRequirement{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Cleanup()
+	procData, err := runner.StartProcess(cwl.JSONDict{}, []string{"false"}, DOCKER_WORKDIR, "", "", "", ProcessOptions{DockerImage: "image"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if code, done := runner.ExitCode(procData); done {
+			if code != 3 {
+				t.Errorf("Exit code %d, expected 3", code)
+			}
+			if JobSucceeded(TaskRecord{ProcData: procData}, runner) {
+				t.Error("Failed container counted as success")
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("Container never finished")
+}
+
+func TestNativeExitStatus(t *testing.T) {
+	if code := nativeExitStatus(3, nil); code != 3 {
+		t.Errorf("Exit code %d, expected 3", code)
+	}
+	if code := nativeExitStatus(0, fmt.Errorf("connection reset")); code == 0 {
+		t.Error("Failed ContainerWait recorded as success")
+	}
+}
+
+// a docker client without images that logs its commands to $DOCKER_LOG
+const loggingDocker = `#!/bin/sh
+echo "$@" >> "$DOCKER_LOG"
+case "$1" in
+image) exit 1 ;;
+load) cat > /dev/null; echo "Loaded image: loaded:1" ;;
+esac
+exit 0
+`
+
+func TestPrepImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer useDocker(t, dir, loggingDocker)()
+	dockerLog := filepath.Join(dir, "docker.log")
+	defer os.Unsetenv("DOCKER_LOG")
+	os.Setenv("DOCKER_LOG", dockerLog)
+	tar := filepath.Join(dir, "image.tar")
+	if err := ioutil.WriteFile(tar, []byte("tar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		docker   cwl.DockerRequirement
+		commands []string
+	}{
+		{cwl.DockerRequirement{DockerPull: "debian:9"}, []string{"pull debian:9"}},
+		{cwl.DockerRequirement{DockerPull: "debian:9", DockerImageId: "mine"}, []string{"pull debian:9", "tag debian:9 mine"}},
+		{cwl.DockerRequirement{DockerLoad: tar, DockerImageId: "mine"}, []string{"load", "tag loaded:1 mine"}},
+		{cwl.DockerRequirement{DockerImport: "https://example.com/image.tar", DockerImageId: "mine"}, []string{"import https://example.com/image.tar mine"}},
+		{cwl.DockerRequirement{DockerFile: "FROM debian:9", DockerImageId: "mine"}, []string{"build -t mine"}},
+	} {
+		os.Remove(dockerLog)
+		runner := DockerRunner{Config: Config{TmpdirPrefix: dir}, Docker: c.docker}
+		image := c.docker.ImageName()
+		if err := runner.prepImage(image); err != nil {
+			t.Errorf("%+v: %s", c.docker, err)
+			continue
+		}
+		data, _ := ioutil.ReadFile(dockerLog)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		expected := append([]string{"image inspect " + image}, c.commands...)
+		if len(lines) != len(expected) {
+			t.Errorf("%+v ran %q, expected %q", c.docker, lines, expected)
+			continue
+		}
+		for i := range lines {
+			if !strings.HasPrefix(lines[i], expected[i]) {
+				t.Errorf("%+v ran %q, expected %q", c.docker, lines, expected)
+				break
+			}
+		}
+	}
+}
+
+func TestDockerWorkDir(t *testing.T) {
+	if d := dockerWorkDir(cwl.DockerRequirement{}); d != DOCKER_WORKDIR {
+		t.Errorf("Work directory %s, expected %s", d, DOCKER_WORKDIR)
+	}
+	if d := dockerWorkDir(cwl.DockerRequirement{DockerOutputDirectory: "/out"}); d != "/out" {
+		t.Errorf("Work directory %s, expected the dockerOutputDirectory", d)
+	}
+}
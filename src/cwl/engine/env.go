@@ -0,0 +1,72 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// jobEnvironment returns the variables a job sets: HOME and TMPDIR point to
+// the job's output and temporary directories, followed by the evaluated
+// EnvVarRequirement, which may override them
+func jobEnvironment(job cwl.Job, js_eval cwl.JSEvaluator, runner JobRunner) (map[string]string, error) {
+	env := map[string]string{
+		"HOME":   runner.GetWorkDirPath(),
+		"TMPDIR": runner.GetTmpDirPath(),
+	}
+	for k, v := range job.Env {
+		e, err := js_eval.EvaluateExpressionString(v, nil)
+		if err != nil {
+			return nil, fmt.Errorf("EnvVarRequirement %s: %s", k, err)
+		}
+		env[k] = e
+	}
+	return env, nil
+}
+
+// hostEnvironment returns the variables of the caller's environment that a
+// job keeps: PATH for jobs that do not run in a container, the variables
+// named by PreserveEnvironment, or all of them with
+// PreserveEntireEnvironment
+func hostEnvironment(config Config, container bool) map[string]string {
+	out := map[string]string{}
+	if config.PreserveEntireEnvironment {
+		for _, e := range os.Environ() {
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) == 2 && !(container && kv[0] == "PATH") {
+				out[kv[0]] = kv[1]
+			}
+		}
+		return out
+	}
+	names := append([]string{}, config.PreserveEnvironment...)
+	if !container {
+		names = append(names, "PATH")
+	}
+	for _, n := range names {
+		if v, ok := os.LookupEnv(n); ok {
+			out[n] = v
+		}
+	}
+	return out
+}
+
+// environList merges the host and job variables, job variables winning,
+// into a sorted NAME=VALUE list
+func environList(host map[string]string, env map[string]string) []string {
+	merged := map[string]string{}
+	for k, v := range host {
+		merged[k] = v
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	out := []string{}
+	for k, v := range merged {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
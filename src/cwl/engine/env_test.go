@@ -0,0 +1,100 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvironList(t *testing.T) {
+	got := environList(map[string]string{"PATH": "/bin", "HOME": "/host"}, map[string]string{"HOME": "/job", "A": "1"})
+	if expected := []string{"A=1", "HOME=/job", "PATH=/bin"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Environment %v, expected %v", got, expected)
+	}
+}
+
+func TestHostEnvironment(t *testing.T) {
+	defer os.Unsetenv("CWL_TEST_KEEP")
+	defer os.Unsetenv("CWL_TEST_DROP")
+	os.Setenv("CWL_TEST_KEEP", "keep")
+	os.Setenv("CWL_TEST_DROP", "drop")
+
+	local := hostEnvironment(Config{PreserveEnvironment: []string{"CWL_TEST_KEEP"}}, false)
+	if local["CWL_TEST_KEEP"] != "keep" || local["PATH"] == "" {
+		t.Errorf("Preserved variables missing: %v", local)
+	}
+	if _, ok := local["CWL_TEST_DROP"]; ok {
+		t.Error("Variable passed to the job without being preserved")
+	}
+	if _, ok := hostEnvironment(Config{}, true)["PATH"]; ok {
+		t.Error("Host PATH passed into a container")
+	}
+	entire := hostEnvironment(Config{PreserveEntireEnvironment: true}, true)
+	if entire["CWL_TEST_DROP"] != "drop" {
+		t.Error("PreserveEntireEnvironment dropped a variable")
+	}
+	if _, ok := entire["PATH"]; ok {
+		t.Error("Host PATH passed into a container")
+	}
+}
+
+func TestEnvVarRequirement(t *testing.T) {
+	dir, err := ioutil.TempDir("", "env_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("CWL_TEST_DROP")
+	os.Setenv("CWL_TEST_DROP", "drop")
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  InlineJavascriptRequirement: {}
+  EnvVarRequirement:
+    envDef:
+      GREETING: hello $(inputs.x)
+      TMPDIR: /custom
+baseCommand: env
+inputs:
+  x: string
+stdout: env.txt
+outputs:
+  env:
+    type: string
+    outputBinding:
+      glob: env.txt
+      loadContents: true
+      outputEval: $(self[0].contents)
+  outdir:
+    type: string
+    outputBinding:
+      outputEval: $(runtime.outdir)
+`}, "tool.cwl")
+
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{"x": "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	for _, l := range strings.Split(out["env"].(string), "\n") {
+		if kv := strings.SplitN(l, "=", 2); len(kv) == 2 {
+			env[kv[0]] = kv[1]
+		}
+	}
+	if env["GREETING"] != "hello world" {
+		t.Errorf("GREETING=%q", env["GREETING"])
+	}
+	if env["TMPDIR"] != "/custom" {
+		t.Errorf("EnvVarRequirement did not override TMPDIR: %q", env["TMPDIR"])
+	}
+	if env["HOME"] != out["outdir"] {
+		t.Errorf("HOME=%q, expected the output directory %v", env["HOME"], out["outdir"])
+	}
+	if _, ok := env["CWL_TEST_DROP"]; ok {
+		t.Error("Host variable passed to the job")
+	}
+}
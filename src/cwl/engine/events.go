@@ -0,0 +1,167 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	EVENT_RUN_STARTED  = "run_started"
+	EVENT_STEP_READY   = "step_ready"
+	EVENT_JOB_STARTED  = "job_started"
+	EVENT_JOB_FINISHED = "job_finished"
+	EVENT_STEP_SKIPPED = "step_skipped"
+	EVENT_RUN_FINISHED = "run_finished"
+)
+
+// Event is implemented by all of the event types the Executor sends to its
+// observers. Use a type switch to get at the event specific fields.
+type Event interface {
+	EventType() string
+	EventTime() time.Time
+}
+
+// Observer receives events from an Executor. HandleEvent is called
+// synchronously from the run loop, so it should not block.
+type Observer interface {
+	HandleEvent(event Event)
+}
+
+// ObserverFunc adapts a function to the Observer interface
+type ObserverFunc func(event Event)
+
+func (self ObserverFunc) HandleEvent(event Event) {
+	self(event)
+}
+
+type EventInfo struct {
+	RunID string
+	Time  time.Time
+}
+
+func (self EventInfo) EventTime() time.Time {
+	return self.Time
+}
+
+// Info returns the fields shared by all events
+func (self EventInfo) Info() EventInfo {
+	return self
+}
+
+type RunStartedEvent struct {
+	EventInfo
+	Inputs cwl.JSONDict
+}
+
+type StepReadyEvent struct {
+	EventInfo
+	StepID string
+}
+
+type JobStartedEvent struct {
+	EventInfo
+	StepID      string
+	JobID       string
+	CommandLine []string
+	DockerImage string
+}
+
+type JobFinishedEvent struct {
+	EventInfo
+	StepID   string
+	JobID    string
+	ExitCode int
+	Status   string
+	Outputs  cwl.JSONDict
+	Error    error
+}
+
+// StepSkippedEvent is sent for steps that are not run, because they were
+// restored from a previous run or their outputs were found in the cache
+type StepSkippedEvent struct {
+	EventInfo
+	StepID  string
+	Reason  string
+	Outputs cwl.JSONDict
+}
+
+type RunFinishedEvent struct {
+	EventInfo
+	Status  string
+	Outputs cwl.JSONDict
+	Error   error
+}
+
+func (self RunStartedEvent) EventType() string  { return EVENT_RUN_STARTED }
+func (self StepReadyEvent) EventType() string   { return EVENT_STEP_READY }
+func (self JobStartedEvent) EventType() string  { return EVENT_JOB_STARTED }
+func (self JobFinishedEvent) EventType() string { return EVENT_JOB_FINISHED }
+func (self StepSkippedEvent) EventType() string { return EVENT_STEP_SKIPPED }
+func (self RunFinishedEvent) EventType() string { return EVENT_RUN_FINISHED }
+
+// LogObserver writes every event to a Logger, giving one human readable
+// summary line and one JSON record, tagged with the event type and ids,
+// per event
+type LogObserver struct {
+	Log *cwl.Logger
+}
+
+func NewLogObserver(logger *cwl.Logger) LogObserver {
+	return LogObserver{Log: logger}
+}
+
+func (self LogObserver) HandleEvent(event Event) {
+	logger := self.Log
+	if e, ok := event.(interface {
+		Info() EventInfo
+	}); ok && e.Info().RunID != "" {
+		logger = logger.With(cwl.LOG_RUN_ID, e.Info().RunID)
+	}
+	fields := map[string]interface{}{cwl.LOG_EVENT: event.EventType()}
+	level := cwl.INFO
+	msg := ""
+	switch e := event.(type) {
+	case RunStartedEvent:
+		msg = "Run started"
+		fields["inputs"] = e.Inputs.Normalize()
+	case StepReadyEvent:
+		logger = logger.With(cwl.LOG_STEP_ID, e.StepID)
+		level = cwl.DEBUG
+		msg = "Step ready"
+	case JobStartedEvent:
+		logger = logger.With(cwl.LOG_STEP_ID, e.StepID).With(cwl.LOG_JOB_ID, e.JobID)
+		msg = fmt.Sprintf("Job started: %s", strings.Join(e.CommandLine, " "))
+		fields["command_line"] = e.CommandLine
+		if e.DockerImage != "" {
+			fields["docker_image"] = e.DockerImage
+		}
+	case JobFinishedEvent:
+		logger = logger.With(cwl.LOG_STEP_ID, e.StepID).With(cwl.LOG_JOB_ID, e.JobID)
+		msg = fmt.Sprintf("Job finished: %s (exit code %d)", e.Status, e.ExitCode)
+		fields["status"] = e.Status
+		fields["exit_code"] = e.ExitCode
+		if e.Outputs != nil {
+			fields["outputs"] = e.Outputs.Normalize()
+		}
+		if e.Error != nil {
+			level = cwl.ERROR
+			fields["error"] = e.Error.Error()
+			msg = fmt.Sprintf("%s: %s", msg, e.Error)
+		}
+	case StepSkippedEvent:
+		logger = logger.With(cwl.LOG_STEP_ID, e.StepID)
+		msg = fmt.Sprintf("Step skipped: %s", e.Reason)
+		fields["reason"] = e.Reason
+	case RunFinishedEvent:
+		msg = fmt.Sprintf("Run finished: %s", e.Status)
+		fields["status"] = e.Status
+		if e.Error != nil {
+			level = cwl.ERROR
+			fields["error"] = e.Error.Error()
+			msg = fmt.Sprintf("%s: %s", msg, e.Error)
+		}
+	}
+	logger.Log(level, msg, fields)
+}
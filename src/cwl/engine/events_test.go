@@ -0,0 +1,138 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// eventLog collects the events of a run
+type eventLog struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func (self *eventLog) HandleEvent(event Event) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.events = append(self.events, event)
+}
+
+// stepEvents returns the types of the events sent for step, in order
+func (self *eventLog) stepEvents(step string) []string {
+	out := []string{}
+	for _, e := range self.events {
+		id := ""
+		switch e := e.(type) {
+		case StepReadyEvent:
+			id = e.StepID
+		case JobStartedEvent:
+			id = e.StepID
+		case JobFinishedEvent:
+			id = e.StepID
+		case StepSkippedEvent:
+			id = e.StepID
+		}
+		if id == step {
+			out = append(out, e.EventType())
+		}
+	}
+	return out
+}
+
+// commandLine runs a tool and returns the command line its job started with
+func commandLine(t *testing.T, tool string, inputs cwl.JSONDict) []string {
+	dir, err := ioutil.TempDir("", "events_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": tool}, "tool.cwl")
+	exec := testExecutor(t, dir, 1)
+	events := &eventLog{}
+	exec.AddObserver(events)
+	if _, _, err := exec.Run(context.Background(), doc, inputs); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events.events {
+		if e, ok := e.(JobStartedEvent); ok {
+			return e.CommandLine
+		}
+	}
+	t.Fatal("No job was started")
+	return nil
+}
+
+func TestRunEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{
+		"wf.cwl":   twoStepWorkflow("echo.cwl", "echo.cwl"),
+		"echo.cwl": shellTool("echo hi"),
+	}, "wf.cwl")
+
+	exec := testExecutor(t, dir, 1)
+	events := &eventLog{}
+	exec.AddObserver(events)
+	if _, _, err := exec.Run(context.Background(), doc, cwl.JSONDict{"x": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if first := events.events[0].EventType(); first != EVENT_RUN_STARTED {
+		t.Errorf("First event %s", first)
+	}
+	last, ok := events.events[len(events.events)-1].(RunFinishedEvent)
+	if !ok || last.Status != STATUS_SUCCESS || last.Error != nil {
+		t.Errorf("Last event %#v", events.events[len(events.events)-1])
+	}
+	expected := []string{EVENT_STEP_READY, EVENT_JOB_STARTED, EVENT_JOB_FINISHED}
+	for _, step := range []string{"a", "b"} {
+		if got := events.stepEvents(step); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Step %s events %v, expected %v", step, got, expected)
+		}
+	}
+	for _, e := range events.events {
+		if e, ok := e.(JobStartedEvent); ok && !reflect.DeepEqual(e.CommandLine, []string{"sh", "-c", "echo hi"}) {
+			t.Errorf("Job of step %s started with %v", e.StepID, e.CommandLine)
+		}
+	}
+}
+
+func TestFailedRunEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{"fail.cwl": shellTool("exit 3")}, "fail.cwl")
+
+	exec := testExecutor(t, dir, 1)
+	events := &eventLog{}
+	exec.AddObserver(events)
+	if _, _, err := exec.Run(context.Background(), doc, cwl.JSONDict{"x": "hi"}); err == nil {
+		t.Fatal("Failing job did not fail the run")
+	}
+	finished := 0
+	for _, e := range events.events {
+		switch e := e.(type) {
+		case JobFinishedEvent:
+			finished++
+			if e.ExitCode != 3 || e.Status != STATUS_FAILED || e.Error == nil {
+				t.Errorf("Job finished with %#v", e)
+			}
+		case RunFinishedEvent:
+			if e.Status != STATUS_FAILED || e.Error == nil {
+				t.Errorf("Run finished with %#v", e)
+			}
+		}
+	}
+	if finished != 1 {
+		t.Errorf("%d job finished events, expected 1", finished)
+	}
+}
@@ -0,0 +1,353 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+	"sync"
+	"time"
+)
+
+// RunnerFactory returns the JobRunner that a job should be executed with
+type RunnerFactory func(job cwl.Job, config Config) (JobRunner, error)
+
+// DefaultRunnerFactory runs expressions in the ExpressionRunner, jobs with
+// a DockerRequirement in the DockerRunner and everything else locally.
+func DefaultRunnerFactory(job cwl.Job, config Config) (JobRunner, error) {
+	if job.JobType == cwl.EXPRESSION {
+		return NewExpressionRunner(config), nil
+	}
+	if job.DockerImage != "" {
+		return NewDockerRunner(config, job.Docker)
+	}
+	return NewLocalRunner(config)
+}
+
+// Executor runs CWL documents to completion. It can be embedded in other
+// programs, cwlgo-tool is a thin wrapper around it.
+//
+//	exec, err := cwl_engine.NewExecutor(config, cwl_engine.DefaultRunnerFactory)
+//	outputs, steps, err := exec.Run(context.Background(), doc, inputs)
+type Executor struct {
+	Config    Config
+	NewRunner RunnerFactory
+	Cache     *JobCache
+	// RunState is optional, when set progress is persisted to its run
+	// directory and steps it records as complete are not run again.
+	RunState  *RunState
+	RunID     string
+	Observers []Observer
+	Log       *cwl.Logger
+	// Scheduler decides when ready jobs may start, it can be shared by
+	// executors running on the same machine
+	Scheduler *Scheduler
+	jobCount  int
+	runners   []JobRunner
+	//host work directories of the jobs run for each step
+	workDirs map[string]string
+	mutex    sync.Mutex
+}
+
+func NewExecutor(config Config, factory RunnerFactory) (*Executor, error) {
+	if factory == nil {
+		factory = DefaultRunnerFactory
+	}
+	out := &Executor{Config: config, NewRunner: factory, RunID: fmt.Sprintf("run_%x", time.Now().UnixNano()), Log: cwl.Logging}
+	out.Scheduler = NewScheduler(config.MaxCores, config.MaxRam)
+	if config.CacheDir != "" {
+		cache, err := NewJobCache(config.CacheDir)
+		if err != nil {
+			return nil, err
+		}
+		out.Cache = cache
+	}
+	return out, nil
+}
+
+// AddObserver registers an observer for the events of every following run
+func (self *Executor) AddObserver(observer Observer) {
+	self.Observers = append(self.Observers, observer)
+}
+
+func (self *Executor) emit(event Event) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for _, o := range self.Observers {
+		o.HandleEvent(event)
+	}
+}
+
+func (self *Executor) eventInfo() EventInfo {
+	return EventInfo{RunID: self.RunID, Time: time.Now()}
+}
+
+// Run executes doc with inputs, returning the output object and the status
+// of every step that was run or restored. Steps run as soon as their
+// inputs are ready and the Scheduler has room for them. If a step fails,
+// the run stops and the error names the step; the returned step map shows
+// how far the run got.
+func (self *Executor) Run(ctx context.Context, doc cwl.CWLDoc, inputs cwl.JSONDict) (cwl.JSONDict, map[string]StepRecord, error) {
+	self.emit(RunStartedEvent{EventInfo: self.eventInfo(), Inputs: inputs})
+	self.runners = []JobRunner{}
+	self.workDirs = map[string]string{}
+	out, steps, err := self.run(ctx, doc, inputs)
+	if err == nil && self.Config.Outdir != "" && !self.Config.LeaveOutputs {
+		//the steps restored from the run state have their work dirs too
+		workDirs := []string{}
+		if !self.Config.LeaveTmpdir {
+			for _, s := range steps {
+				if s.WorkDir != "" {
+					workDirs = append(workDirs, s.WorkDir)
+				}
+			}
+		}
+		out, err = StageOutputs(out, self.Config.Outdir, workDirs)
+	}
+	self.cleanup(err, steps)
+	status := STATUS_SUCCESS
+	if err != nil {
+		status = STATUS_FAILED
+	}
+	self.emit(RunFinishedEvent{EventInfo: self.eventInfo(), Status: status, Outputs: out, Error: err})
+	return out, steps, err
+}
+
+func (self *Executor) run(ctx context.Context, doc cwl.CWLDoc, inputs cwl.JSONDict) (cwl.JSONDict, map[string]StepRecord, error) {
+	logger := self.Log.With(cwl.LOG_RUN_ID, self.RunID)
+	steps := map[string]StepRecord{}
+	var graphState cwl.JSONDict
+	if self.RunState != nil {
+		self.RunState.Inputs = inputs
+		graphState = self.RunState.Restore(doc)
+		for k, v := range self.RunState.Steps {
+			steps[k] = v
+			self.emit(StepSkippedEvent{EventInfo: self.eventInfo(), StepID: k, Reason: "restored", Outputs: v.Outputs})
+		}
+		if err := self.RunState.Save(graphState); err != nil {
+			logger.Warnf("Unable to save run state: %s", err)
+		}
+	} else {
+		graphState = doc.NewGraphState(inputs)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan stepResult)
+	running := map[string]bool{}
+	var runErr error
+	for {
+		if runErr == nil && !doc.Done(graphState) {
+			logger.Debugf("StateGraph: %s", graphState.ToString())
+			for _, step := range doc.ReadySteps(graphState) {
+				if running[step] {
+					continue
+				}
+				self.emit(StepReadyEvent{EventInfo: self.eventInfo(), StepID: step})
+				self.setStatus(step, STATUS_RUNNING)
+				job, err := doc.GenerateJob(step, graphState)
+				if err != nil {
+					steps[step] = StepRecord{Status: STATUS_FAILED}
+					self.setStatus(step, STATUS_FAILED)
+					runErr = err
+					cancel()
+					break
+				}
+				running[step] = true
+				go func(step string, job cwl.Job) {
+					out, err := self.runStep(ctx, step, job)
+					results <- stepResult{Step: step, Outputs: out, Error: err}
+				}(step, job)
+			}
+			//steps are left whose inputs will never be ready, the run
+			//fails rather than returning partial outputs
+			if runErr == nil && len(running) == 0 {
+				runErr = fmt.Errorf("No jobs found")
+			}
+		}
+		if len(running) == 0 {
+			break
+		}
+		//wait for a step to finish, its outputs may make others ready
+		r := <-results
+		delete(running, r.Step)
+		if r.Error != nil {
+			steps[r.Step] = StepRecord{Status: STATUS_FAILED}
+			self.setStatus(r.Step, STATUS_FAILED)
+			if runErr == nil {
+				runErr = r.Error
+				cancel()
+			}
+			continue
+		}
+		self.mutex.Lock()
+		workDir := self.workDirs[r.Step]
+		self.mutex.Unlock()
+		steps[r.Step] = StepRecord{Status: STATUS_SUCCESS, Outputs: r.Outputs, WorkDir: workDir}
+		graphState = doc.UpdateStepResults(graphState, r.Step, r.Outputs)
+		if self.RunState != nil {
+			if err := self.RunState.StepDone(r.Step, r.Outputs, workDir, graphState); err != nil {
+				logger.Warnf("Unable to save run state: %s", err)
+			}
+		}
+	}
+	if runErr != nil {
+		return nil, steps, runErr
+	}
+	out := doc.GetResults(graphState)
+	logger.Debugf("doc results: %#v", out)
+	return out, steps, nil
+}
+
+type stepResult struct {
+	Step    string
+	Outputs cwl.JSONDict
+	Error   error
+}
+
+// runStep runs the job of a step once the Scheduler admits it
+func (self *Executor) runStep(ctx context.Context, step string, job cwl.Job) (cwl.JSONDict, error) {
+	runner, err := self.NewRunner(job, self.Config)
+	if err != nil {
+		return nil, fmt.Errorf("Step %s runner error: %s", step, err)
+	}
+	self.mutex.Lock()
+	self.runners = append(self.runners, runner)
+	self.workDirs[step] = runner.GetHostWorkDir()
+	self.jobCount += 1
+	jobId := fmt.Sprintf("%s_job_%d", self.RunID, self.jobCount)
+	self.mutex.Unlock()
+	logger := self.Log.With(cwl.LOG_RUN_ID, self.RunID).With(cwl.LOG_STEP_ID, step).With(cwl.LOG_JOB_ID, jobId)
+	logger.Debugf("Job: %#v", job)
+	mapper := RuntimeMapper{Runner: runner, Log: logger}
+
+	if job.NetworkAccess == "" && self.Config.DefaultNetworkAccess {
+		job.NetworkAccess = "true"
+	}
+	resources, err := EvaluateResources(job, runner)
+	if err != nil {
+		return nil, fmt.Errorf("Step %s ResourceRequirement %s", step, err)
+	}
+	limit, err := timeLimit(job, jobRuntime(runner, resources), self.Config)
+	if err != nil {
+		return nil, fmt.Errorf("Step %s ToolTimeLimit %s", step, err)
+	}
+	//expressions are evaluated by the engine and don't take any resources
+	if job.JobType == cwl.COMMAND {
+		if err := self.Scheduler.Acquire(ctx, resources); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("Step %s can't be scheduled: %s", step, err)
+		}
+		defer self.Scheduler.Release(resources)
+	}
+	task, err := StartJob(job, runner, mapper, self.Cache, resources, logger)
+	if err != nil {
+		return nil, fmt.Errorf("Step %s runtime error: %s", step, err)
+	}
+	if task.Cached != nil {
+		self.emit(StepSkippedEvent{EventInfo: self.eventInfo(), StepID: step, Reason: "cached", Outputs: task.Cached})
+		return task.Cached, nil
+	}
+	self.emit(JobStartedEvent{EventInfo: self.eventInfo(), StepID: step, JobID: jobId, CommandLine: task.CmdArgs, DockerImage: job.DockerImage})
+
+	var deadline <-chan time.Time
+	if limit > 0 {
+		timer := time.NewTimer(limit)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	sleepTime := time.Microsecond
+	for !JobDone(task, runner) {
+		select {
+		case <-ctx.Done():
+			if err := runner.Kill(task.ProcData); err != nil {
+				logger.Warnf("Unable to kill job: %s", err)
+			}
+			self.emit(JobFinishedEvent{EventInfo: self.eventInfo(), StepID: step, JobID: jobId, ExitCode: -1, Status: STATUS_FAILED, Error: ctx.Err()})
+			return nil, ctx.Err()
+		case <-deadline:
+			if err := runner.Kill(task.ProcData); err != nil {
+				logger.Warnf("Unable to kill job: %s", err)
+			}
+			err := fmt.Errorf("Step %s timed out after %s", step, limit)
+			self.emit(JobFinishedEvent{EventInfo: self.eventInfo(), StepID: step, JobID: jobId, ExitCode: -1, Status: STATUS_FAILED, Error: err})
+			return nil, err
+		case <-time.After(sleepTime):
+		}
+		if sleepTime < time.Second*10 {
+			sleepTime += time.Millisecond
+		}
+	}
+
+	code, _ := runner.ExitCode(task.ProcData)
+	if !JobSucceeded(task, runner) {
+		err := fmt.Errorf("Step %s failed with exit code %d", step, code)
+		self.emit(JobFinishedEvent{EventInfo: self.eventInfo(), StepID: step, JobID: jobId, ExitCode: code, Status: STATUS_FAILED, Error: err})
+		return nil, err
+	}
+	out, err := CleanupJob(task, runner)
+	status := STATUS_SUCCESS
+	if err != nil {
+		status = STATUS_FAILED
+	}
+	self.emit(JobFinishedEvent{EventInfo: self.eventInfo(), StepID: step, JobID: jobId, ExitCode: code, Status: status, Outputs: out, Error: err})
+	return out, err
+}
+
+// timeLimit evaluates the ToolTimeLimit of a job, jobs without one get the
+// configured default. 0 is no limit.
+func timeLimit(job cwl.Job, runtime cwl.JSONDict, config Config) (time.Duration, error) {
+	if job.TimeLimit == "" {
+		return config.DefaultTimeout, nil
+	}
+	js_eval := cwl.JSEvaluator{Inputs: job.InputData, Runtime: runtime}
+	seconds, ok, err := resourceValue(job.TimeLimit, js_eval)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return config.DefaultTimeout, nil
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// cleanup removes the work directories of the run's jobs and of the steps
+// restored from the run state. They are kept if asked to, if the outputs
+// were left in place, or if a failed run can still be resumed from its run
+// state.
+func (self *Executor) cleanup(runErr error, steps map[string]StepRecord) {
+	logger := self.Log.With(cwl.LOG_RUN_ID, self.RunID)
+	if self.Config.LeaveTmpdir || self.Config.LeaveOutputs {
+		return
+	}
+	if runErr != nil && self.RunState != nil {
+		logger.Infof("Keeping work directories to resume from %s", self.RunState.RunDir)
+		return
+	}
+	for _, r := range self.runners {
+		if err := r.Cleanup(); err != nil {
+			logger.Warnf("Unable to remove work directory %s: %s", r.GetHostWorkDir(), err)
+		}
+	}
+	//work dirs of the jobs above are gone already
+	for _, s := range steps {
+		if s.WorkDir == "" {
+			continue
+		}
+		os.Remove(s.WorkDir + ".result")
+		os.RemoveAll(s.WorkDir + LITERALS_SUFFIX)
+		if err := os.RemoveAll(s.WorkDir); err != nil {
+			logger.Warnf("Unable to remove work directory %s: %s", s.WorkDir, err)
+		}
+	}
+}
+
+func (self *Executor) setStatus(step string, status string) {
+	if self.RunState != nil {
+		if err := self.RunState.SetStatus(step, status); err != nil {
+			self.Log.With(cwl.LOG_RUN_ID, self.RunID).With(cwl.LOG_STEP_ID, step).Warnf("Unable to save run state: %s", err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// parseDoc writes a CWL document and the tools it runs to dir and parses it
+func parseDoc(t *testing.T, dir string, files map[string]string, main string) cwl.CWLDoc {
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	docs, err := cwl.Parse(filepath.Join(dir, main))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, ok := docs.Elements[docs.Main]; ok {
+		return doc
+	}
+	return docs.Elements["#"+docs.Main]
+}
+
+func twoStepWorkflow(a, b string) string {
+	return `cwlVersion: v1.0
+class: Workflow
+inputs:
+  x: string
+outputs: []
+steps:
+  a:
+    run: ` + a + `
+    in: {x: x}
+    out: []
+  b:
+    run: ` + b + `
+    in: {x: x}
+    out: []
+`
+}
+
+func shellTool(script string) string {
+	return `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: [sh, -c, "` + script + `"]
+inputs:
+  x: string
+outputs: []
+`
+}
+
+func testExecutor(t *testing.T, dir string, cores int) *Executor {
+	exec, err := NewExecutor(Config{TmpdirPrefix: dir, TmpOutdirPrefix: dir, MaxCores: cores, MaxRam: 1024}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exec
+}
+
+func TestExecutorParallelSteps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "executor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{
+		"wf.cwl":    twoStepWorkflow("sleep.cwl", "sleep.cwl"),
+		"sleep.cwl": shellTool("sleep 1"),
+	}, "wf.cwl")
+
+	start := time.Now()
+	_, steps, err := testExecutor(t, dir, 2).Run(context.Background(), doc, cwl.JSONDict{"x": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 1800*time.Millisecond {
+		t.Errorf("Steps did not run in parallel, took %s", elapsed)
+	}
+	for _, s := range []string{"a", "b"} {
+		if steps[s].Status != STATUS_SUCCESS {
+			t.Errorf("Step %s: %s", s, steps[s].Status)
+		}
+	}
+
+	//with one core the steps are queued
+	start = time.Now()
+	if _, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{"x": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("Steps ran beyond the scheduler's capacity, took %s", elapsed)
+	}
+}
+
+func TestExecutorFailureCancelsSiblings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "executor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+	doc := parseDoc(t, dir, map[string]string{
+		"wf.cwl":   twoStepWorkflow("fail.cwl", "slow.cwl"),
+		"fail.cwl": shellTool("exit 1"),
+		"slow.cwl": shellTool("sleep 2 && touch " + marker),
+	}, "wf.cwl")
+
+	start := time.Now()
+	_, steps, err := testExecutor(t, dir, 2).Run(context.Background(), doc, cwl.JSONDict{"x": "hi"})
+	if err == nil {
+		t.Fatal("Failing step did not fail the run")
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("Run waited for the running sibling, took %s", elapsed)
+	}
+	if steps["b"].Status != STATUS_FAILED {
+		t.Errorf("Cancelled sibling has status %s", steps["b"].Status)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("Cancelled sibling was not killed")
+	}
+}
+
+func TestExecutorWorkflowOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "executor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{
+		"wf.cwl": `cwlVersion: v1.0
+class: Workflow
+inputs:
+  x: string
+outputs:
+  from_input:
+    type: string
+    outputSource: x
+  from_step:
+    type: string
+    outputSource: echo/out
+steps:
+  echo:
+    run: echo.cwl
+    in: {x: x}
+    out: [out]
+`,
+		"echo.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: [echo, -n]
+inputs:
+  x:
+    type: string
+    inputBinding: {position: 1}
+outputs:
+  out:
+    type: string
+    outputBinding:
+      glob: out.txt
+      loadContents: true
+      outputEval: $(self[0].contents)
+stdout: out.txt
+`,
+	}, "wf.cwl")
+
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{"x": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["from_input"] != "hi" || out["from_step"] != "hi" {
+		t.Errorf("Workflow outputs %v", out)
+	}
+}
+
+// a workflow whose steps can't all get their inputs fails instead of
+// returning partial outputs
+func TestExecutorNoJobsFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "executor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{
+		"wf.cwl": `cwlVersion: v1.0
+class: Workflow
+inputs:
+  x: string
+outputs: []
+steps:
+  a:
+    run: ok.cwl
+    in: {x: x}
+    out: []
+  b:
+    run: ok.cwl
+    in: {x: a/missing}
+    out: []
+`,
+		"ok.cwl": shellTool("true"),
+	}, "wf.cwl")
+
+	_, steps, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{"x": "hi"})
+	if err == nil {
+		t.Fatal("Stalled workflow succeeded")
+	}
+	if steps["a"].Status != STATUS_SUCCESS {
+		t.Errorf("Step a: %s", steps["a"].Status)
+	}
+	if _, ok := steps["b"]; ok {
+		t.Errorf("Step b was run")
+	}
+}
+
+func TestTimeLimitRuntime(t *testing.T) {
+	job := cwl.Job{TimeLimit: "$(runtime.cores * 10)", InputData: cwl.JSONDict{}}
+	limit, err := timeLimit(job, cwl.JSONDict{"cores": 3}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit != 30*time.Second {
+		t.Errorf("Time limit %s, expected 30s", limit)
+	}
+}
+
+func TestTimeLimit(t *testing.T) {
+	config := Config{DefaultTimeout: 3 * time.Second}
+	for _, c := range []struct {
+		limit    string
+		expected time.Duration
+	}{
+		{"", 3 * time.Second},
+		{"0", 0},
+		{"2.5", 2500 * time.Millisecond},
+		{"$(inputs.seconds)", 7 * time.Second},
+	} {
+		job := cwl.Job{TimeLimit: c.limit, InputData: cwl.JSONDict{"seconds": 7}}
+		limit, err := timeLimit(job, cwl.JSONDict{}, config)
+		if err != nil {
+			t.Errorf("Time limit %q: %s", c.limit, err)
+		} else if limit != c.expected {
+			t.Errorf("Time limit %q is %s, expected %s", c.limit, limit, c.expected)
+		}
+	}
+	if _, err := timeLimit(cwl.Job{TimeLimit: "-1"}, cwl.JSONDict{}, config); err == nil {
+		t.Error("Negative time limit accepted")
+	}
+}
+
+func TestToolTimeLimitKill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "executor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+	doc := parseDoc(t, dir, map[string]string{"slow.cwl": shellTool("sleep 2 && touch "+marker) + `requirements:
+  ToolTimeLimit: {timelimit: 1}
+`}, "slow.cwl")
+
+	start := time.Now()
+	if _, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{"x": "hi"}); err == nil {
+		t.Fatal("Job ran past its time limit")
+	}
+	if elapsed := time.Since(start); elapsed > 1800*time.Millisecond {
+		t.Errorf("Run waited for the job, took %s", elapsed)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("Job that timed out was not killed")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"cwl"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"path/filepath"
 )
 
@@ -16,6 +15,10 @@ type ExpressionRunner struct {
 	Config Config
 }
 
+func (self ExpressionRunner) GetTmpDirPath() string {
+	return "/tmp"
+}
+
 func (self ExpressionRunner) GetWorkDirPath() string {
 	return "/tmp"
 }
@@ -32,17 +35,17 @@ func (self ExpressionRunner) ReadFile(location string) ([]byte, error) {
 	//return []byte{}, fmt.Errorf("No files in expression engine")
 }
 
-func (self ExpressionRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin, dockerImage string) (cwl.JSONDict, error) {
-	log.Printf("Running Expression %s", cmd_args[0])
-	log.Printf("Expression Inputs: %#v", inputs)
+func (self ExpressionRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin string, options ProcessOptions) (cwl.JSONDict, error) {
+	cwl.Debugf("Running Expression %s", cmd_args[0])
+	cwl.Debugf("Expression Inputs: %#v", inputs)
 
-	js_eval := cwl.JSEvaluator{Inputs: inputs}
+	js_eval := cwl.JSEvaluator{Inputs: inputs, Runtime: jobRuntime(self, options.Resources)}
 
 	out, err := js_eval.EvaluateExpressionObject(cmd_args[0], nil)
 	if err != nil {
 		return cwl.JSONDict{}, fmt.Errorf("ExpressionTool Failure: %s", err)
 	}
-	log.Printf("expression out: %s", out)
+	cwl.Debugf("expression out: %s", out)
 	return cwl.JSONDict{"output": out}, nil
 }
 
@@ -50,7 +53,19 @@ func (self ExpressionRunner) ExitCode(prodData cwl.JSONDict) (int, bool) {
 	return 0, true
 }
 
-func (self ExpressionRunner) GetOutput(prodData cwl.JSONDict) cwl.JSONDict {
+// Kill does nothing, expressions are evaluated before StartProcess returns
+func (self ExpressionRunner) Kill(procData cwl.JSONDict) error {
+	return nil
+}
+
+func (self ExpressionRunner) GetOutput(prodData cwl.JSONDict) (cwl.JSONDict, bool) {
+	return prodData["output"].(cwl.JSONDict), true
+}
+
+func (self ExpressionRunner) GetHostWorkDir() string {
+	return ""
+}
 
-	return prodData["output"].(cwl.JSONDict)
+func (self ExpressionRunner) Cleanup() error {
+	return nil
 }
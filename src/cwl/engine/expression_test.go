@@ -0,0 +1,37 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExpressionToolRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expression_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{"expr.cwl": `cwlVersion: v1.0
+class: ExpressionTool
+requirements:
+  InlineJavascriptRequirement: {}
+inputs: []
+outputs:
+  cores: int
+  ram: int
+  tmpdir: string
+expression: '$({"cores": runtime.cores, "ram": runtime.ram, "tmpdir": runtime.tmpdir})'
+`}, "expr.cwl")
+
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the default ResourceRequirement, not a made up number of cores
+	if out["cores"] != 1 || out["ram"] == nil || out["tmpdir"] == nil {
+		t.Errorf("Expression saw runtime %v", out)
+	}
+}
@@ -0,0 +1,136 @@
+package cwl_engine
+
+import (
+	"crypto/sha1"
+	"cwl"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// NormalizeFile fills in the fields of a File or Directory object: location
+// becomes a local path, path is what toPath maps it to (the location if
+// toPath is nil), and basename, dirname, nameroot, nameext, size and
+// checksum are set from the file. Existing basename, format and contents
+// are kept. Secondary files and listings are normalized as well.
+func NormalizeFile(in map[interface{}]interface{}, baseDir string, toPath func(string) string) (map[interface{}]interface{}, error) {
+	out := map[interface{}]interface{}{}
+	for k, v := range in {
+		out[k] = v
+	}
+	class, _ := in["class"].(string)
+	loc, ok := in["location"].(string)
+	if !ok || loc == "" {
+		loc, ok = in["path"].(string)
+	}
+	if !ok || loc == "" {
+		//literals only have contents or a listing until they are staged
+		return out, nil
+	}
+	loc = cwl.ResolveLocation(loc, baseDir)
+	out["location"] = loc
+	path := loc
+	if toPath != nil {
+		path = toPath(loc)
+	}
+	out["path"] = path
+	out["dirname"] = filepath.Dir(path)
+	if b, ok := in["basename"].(string); !ok || b == "" {
+		out["basename"] = filepath.Base(loc)
+	}
+	if class == "File" {
+		root, ext := FileNameSplit(out["basename"].(string))
+		out["nameroot"] = root
+		out["nameext"] = ext
+		info, err := os.Stat(loc)
+		if err != nil {
+			return out, err
+		}
+		out["size"] = info.Size()
+		checksum, err := fileChecksum(loc, info)
+		if err != nil {
+			return out, err
+		}
+		out["checksum"] = checksum
+	}
+	if sf, ok := in["secondaryFiles"]; ok {
+		s, err := normalizeFiles(sf, filepath.Dir(loc), toPath)
+		if err != nil {
+			return out, err
+		}
+		out["secondaryFiles"] = s
+	}
+	if listing, ok := in["listing"]; ok {
+		l, err := normalizeFiles(listing, loc, toPath)
+		if err != nil {
+			return out, err
+		}
+		out["listing"] = l
+	}
+	return out, nil
+}
+
+// normalizeFiles normalizes every File and Directory object in x
+func normalizeFiles(x interface{}, baseDir string, toPath func(string) string) (interface{}, error) {
+	if base, ok := x.(cwl.JSONDict); ok {
+		x = map[interface{}]interface{}(base)
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if class, ok := base["class"]; ok && (class == "File" || class == "Directory") {
+			return NormalizeFile(base, baseDir, toPath)
+		}
+		out := map[interface{}]interface{}{}
+		for k, v := range base {
+			o, err := normalizeFiles(v, baseDir, toPath)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = o
+		}
+		return out, nil
+	} else if base, ok := x.([]interface{}); ok {
+		out := []interface{}{}
+		for _, v := range base {
+			o, err := normalizeFiles(v, baseDir, toPath)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, o)
+		}
+		return out, nil
+	}
+	return x, nil
+}
+
+// checksums of files that have not changed since they were last hashed,
+// inputs are normalized again every time they are referenced
+var checksumMemo = struct {
+	sync.Mutex
+	sums map[string]string
+}{sums: map[string]string{}}
+
+func fileChecksum(p string, info os.FileInfo) (string, error) {
+	key := fmt.Sprintf("%s:%d:%d", p, info.Size(), info.ModTime().UnixNano())
+	checksumMemo.Lock()
+	sum, ok := checksumMemo.sums[key]
+	checksumMemo.Unlock()
+	if ok {
+		return sum, nil
+	}
+	hasher := sha1.New()
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	sum = fmt.Sprintf("sha1$%x", hasher.Sum([]byte{}))
+	checksumMemo.Lock()
+	checksumMemo.sums[key] = sum
+	checksumMemo.Unlock()
+	return sum, nil
+}
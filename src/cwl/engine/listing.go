@@ -0,0 +1,94 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// addListings fills in the listing of every Directory in x that does not
+// already have one, following loadListing: nothing for no_listing, the
+// direct entries for shallow_listing, and the whole tree for deep_listing
+func addListings(x interface{}, loadListing string) (interface{}, error) {
+	if loadListing == "" || loadListing == cwl.NO_LISTING {
+		return x, nil
+	}
+	if base, ok := x.(cwl.JSONDict); ok {
+		x = map[interface{}]interface{}(base)
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if base["class"] == "Directory" {
+			if _, ok := base["listing"]; ok {
+				return base, nil
+			}
+			loc, ok := base["location"].(string)
+			if !ok || loc == "" {
+				return base, nil
+			}
+			listing, err := listDirectory(cwl.ResolveLocation(loc, ""), loadListing == cwl.DEEP_LISTING)
+			if err != nil {
+				return nil, err
+			}
+			out := map[interface{}]interface{}{}
+			for k, v := range base {
+				out[k] = v
+			}
+			out["listing"] = listing
+			return out, nil
+		}
+		if _, ok := base["class"]; ok {
+			return base, nil
+		}
+		out := map[interface{}]interface{}{}
+		for k, v := range base {
+			o, err := addListings(v, loadListing)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = o
+		}
+		return out, nil
+	} else if base, ok := x.([]interface{}); ok {
+		out := []interface{}{}
+		for _, v := range base {
+			o, err := addListings(v, loadListing)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, o)
+		}
+		return out, nil
+	}
+	return x, nil
+}
+
+func listDirectory(dir string, deep bool) ([]interface{}, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			d := map[interface{}]interface{}{"class": "Directory", "location": p, "basename": e.Name()}
+			if deep {
+				l, err := listDirectory(p, deep)
+				if err != nil {
+					return nil, err
+				}
+				d["listing"] = l
+			}
+			out = append(out, d)
+		} else {
+			out = append(out, map[interface{}]interface{}{"class": "File", "location": p, "basename": e.Name()})
+		}
+	}
+	return out, nil
+}
+
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
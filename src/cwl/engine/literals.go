@@ -0,0 +1,161 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LITERALS_SUFFIX names the directory, next to a job's work directory,
+// that File and Directory literals are written to
+const LITERALS_SUFFIX = ".literals"
+
+// isLiteral reports whether x is a File with contents or a Directory with
+// a listing that does not exist on disk yet
+func isLiteral(x map[interface{}]interface{}) bool {
+	class := x["class"]
+	if class != "File" && class != "Directory" {
+		return false
+	}
+	if loc, ok := x["location"].(string); ok && loc != "" {
+		return false
+	}
+	if p, ok := x["path"].(string); ok && p != "" {
+		return false
+	}
+	return true
+}
+
+// stageJobLiterals writes the literals of the job's command line arguments
+// to dir, setting the location of their JobFiles
+func stageJobLiterals(args []cwl.JobArgument, dir string) error {
+	for i := range args {
+		if f := args[i].File; f != nil && f.Location == "" && f.Literal != nil {
+			o, err := stageLiteral(f.Literal, dir)
+			if err != nil {
+				return err
+			}
+			f.Location = o["location"].(string)
+			f.Literal = nil
+		}
+		if err := stageJobLiterals(args[i].Children, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StageLiterals writes every File and Directory literal in x to dir and
+// returns x with their locations set
+func StageLiterals(x interface{}, dir string) (interface{}, error) {
+	if base, ok := x.(cwl.JSONDict); ok {
+		o, err := StageLiterals(map[interface{}]interface{}(base), dir)
+		if err != nil {
+			return nil, err
+		}
+		return cwl.JSONDict(o.(map[interface{}]interface{})), nil
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if isLiteral(base) {
+			return stageLiteral(base, dir)
+		}
+		out := map[interface{}]interface{}{}
+		for k, v := range base {
+			o, err := StageLiterals(v, dir)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = o
+		}
+		return out, nil
+	} else if base, ok := x.([]interface{}); ok {
+		out := []interface{}{}
+		for _, v := range base {
+			o, err := StageLiterals(v, dir)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, o)
+		}
+		return out, nil
+	}
+	return x, nil
+}
+
+// stageLiteral writes one literal to its own directory under dir, so
+// literals with the same basename do not collide
+func stageLiteral(x map[interface{}]interface{}, dir string) (map[interface{}]interface{}, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	parent, err := ioutil.TempDir(dir, "literal_")
+	if err != nil {
+		return nil, err
+	}
+	return writeLiteral(x, parent)
+}
+
+// writeLiteral creates a File or Directory in parent. Files get their
+// contents, Directories are filled from their listing, and listed entries
+// that already exist are copied in.
+func writeLiteral(x map[interface{}]interface{}, parent string) (map[interface{}]interface{}, error) {
+	out := map[interface{}]interface{}{}
+	for k, v := range x {
+		out[k] = v
+	}
+	class := x["class"]
+	loc, _ := x["location"].(string)
+	if loc == "" {
+		loc, _ = x["path"].(string)
+	}
+	name, _ := x["basename"].(string)
+	if name == "" && loc != "" {
+		name = filepath.Base(cwl.ResolveLocation(loc, ""))
+	} else if name == "" {
+		name = "literal"
+		if class == "File" {
+			name = "literal.txt"
+		}
+	}
+	if filepath.Base(name) != name {
+		return nil, fmt.Errorf("Invalid basename for literal: %s", name)
+	}
+	dst := filepath.Join(parent, name)
+	if loc != "" {
+		if err := copyPath(cwl.ResolveLocation(loc, ""), dst); err != nil {
+			return nil, err
+		}
+	} else if class == "File" {
+		contents, _ := x["contents"].(string)
+		if err := ioutil.WriteFile(dst, []byte(contents), 0644); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := os.Mkdir(dst, 0755); err != nil {
+			return nil, err
+		}
+		if listing, ok := x["listing"].([]interface{}); ok {
+			l := []interface{}{}
+			for _, i := range listing {
+				if base, ok := i.(cwl.JSONDict); ok {
+					i = map[interface{}]interface{}(base)
+				}
+				e, ok := i.(map[interface{}]interface{})
+				if !ok {
+					return nil, fmt.Errorf("Invalid Directory listing entry: %#v", i)
+				}
+				o, err := writeLiteral(e, dst)
+				if err != nil {
+					return nil, err
+				}
+				l = append(l, o)
+			}
+			out["listing"] = l
+		}
+	}
+	out["location"] = dst
+	delete(out, "path")
+	return out, nil
+}
@@ -0,0 +1,98 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStageLiterals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "literals_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	existing := filepath.Join(dir, "existing.txt")
+	if err := ioutil.WriteFile(existing, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inputs := cwl.JSONDict{
+		"a": map[interface{}]interface{}{"class": "File", "basename": "x.txt", "contents": "a"},
+		"b": map[interface{}]interface{}{"class": "File", "basename": "x.txt", "contents": "b"},
+		"d": map[interface{}]interface{}{"class": "Directory", "basename": "d", "listing": []interface{}{
+			map[interface{}]interface{}{"class": "File", "basename": "inner.txt", "contents": "inner"},
+			map[interface{}]interface{}{"class": "File", "location": existing},
+		}},
+		"e": map[interface{}]interface{}{"class": "File", "location": existing},
+	}
+	o, err := StageLiterals(inputs, filepath.Join(dir, "job"+LITERALS_SUFFIX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := o.(cwl.JSONDict)
+	location := func(k string) string {
+		return out[k].(map[interface{}]interface{})["location"].(string)
+	}
+	for k, contents := range map[string]string{"a": "a", "b": "b"} {
+		data, err := ioutil.ReadFile(location(k))
+		if err != nil || string(data) != contents {
+			t.Errorf("Literal %s holds %q %v", k, data, err)
+		}
+	}
+	if location("a") == location("b") {
+		t.Error("Literals with the same basename share a location")
+	}
+	for name, contents := range map[string]string{"inner.txt": "inner", "existing.txt": "existing"} {
+		data, err := ioutil.ReadFile(filepath.Join(location("d"), name))
+		if err != nil || string(data) != contents {
+			t.Errorf("Directory literal entry %s holds %q %v", name, data, err)
+		}
+	}
+	if location("e") != existing {
+		t.Errorf("File with a location was moved to %s", location("e"))
+	}
+
+	bad := map[interface{}]interface{}{"class": "File", "basename": "../x.txt", "contents": "x"}
+	if _, err := StageLiterals(bad, filepath.Join(dir, "bad")); err == nil {
+		t.Error("Literal written outside its directory")
+	}
+}
+
+// a job gets the path of the literal written for its input
+func TestLiteralInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "literals_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  InlineJavascriptRequirement: {}
+baseCommand: cat
+inputs:
+  f:
+    type: File
+    inputBinding: {}
+stdout: out.txt
+outputs:
+  text:
+    type: string
+    outputBinding:
+      glob: out.txt
+      loadContents: true
+      outputEval: $(self[0].contents)
+`}, "tool.cwl")
+
+	inputs := cwl.JSONDict{"f": map[interface{}]interface{}{"class": "File", "basename": "in.txt", "contents": "literal"}}
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["text"] != "literal" {
+		t.Errorf("Job read %#v from the literal", out["text"])
+	}
+}
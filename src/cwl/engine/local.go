@@ -3,13 +3,10 @@ package cwl_engine
 import (
 	"cwl"
 	"fmt"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"syscall"
 )
 
@@ -18,12 +15,18 @@ func NewLocalRunner(config Config) (JobRunner, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create working dir")
 	}
-	return LocalRunner{Config: config, Workdir: workdir}, nil
+	tmpdir, err := ioutil.TempDir(config.TmpdirPrefix, "cwltmp_")
+	if err != nil {
+		os.RemoveAll(workdir)
+		return nil, fmt.Errorf("Unable to create temp dir")
+	}
+	return LocalRunner{Config: config, Workdir: workdir, Tmpdir: tmpdir}, nil
 }
 
 type LocalRunner struct {
 	Config  Config
 	Workdir string
+	Tmpdir  string
 }
 
 func (self LocalRunner) LocationToPath(location string) string {
@@ -34,6 +37,10 @@ func (self LocalRunner) GetWorkDirPath() string {
 	return self.Workdir
 }
 
+func (self LocalRunner) GetTmpDirPath() string {
+	return self.Tmpdir
+}
+
 func (self LocalRunner) Glob(pattern string) []string {
 	matches, _ := filepath.Glob(filepath.Join(self.Workdir, pattern))
 	return matches
@@ -43,69 +50,84 @@ func (self LocalRunner) ReadFile(path string) ([]byte, error) {
 	return ioutil.ReadFile(filepath.Join(self.Workdir, path))
 }
 
-func (self LocalRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin, dockerImage string) (cwl.JSONDict, error) {
+func (self LocalRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin string, options ProcessOptions) (cwl.JSONDict, error) {
 
-	cmd := exec.Command(cmd_args[0], cmd_args[1:]...)
-
-	if stdout != "" {
+	cgroup := ""
+	if options.Resources.Limited {
 		var err error
-		cmd.Stdout, err = os.Create(filepath.Join(workdir, stdout))
+		cgroup, err = createCgroup(self.Workdir, options.Resources)
 		if err != nil {
-			return cwl.JSONDict{}, err
+			cwl.Warnf("Cores of the job are not limited, its RAM only by address space: %s", err)
 		}
+		cmd_args = limitCommand(cmd_args, options.Resources, cgroup)
 	}
-	if stderr != "" {
-		var err error
-		cmd.Stderr, err = os.Create(filepath.Join(workdir, stderr))
-		if err != nil {
-			return cwl.JSONDict{}, err
-		}
-	}
-	if stdin != "" {
-		var err error
-		cmd.Stdin, err = os.Open(stdin)
-		if err != nil {
-			return cwl.JSONDict{}, err
+	cmd := exec.Command(cmd_args[0], cmd_args[1:]...)
+
+	//the job has its own copies of the stream files once started
+	files, err := openStreams(cmd, workdir, stdout, stderr, stdin)
+	defer closeFiles(files)
+	if err != nil {
+		if cgroup != "" {
+			os.Remove(cgroup)
 		}
+		return cwl.JSONDict{}, err
 	}
 	cmd.Dir = workdir
+	cmd.Env = environList(hostEnvironment(self.Config, false), options.Env)
+	//a group of its own lets Kill reach the processes the job starts
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	resFile := self.Workdir + ".result"
 
-	log.Printf("Workdir: %s", workdir)
+	cwl.Debugf("Workdir: %s", workdir)
+	if err := cmd.Start(); err != nil {
+		if cgroup != "" {
+			os.Remove(cgroup)
+		}
+		return cwl.JSONDict{}, err
+	}
 	go func(resfile string) {
-		cmd_err := cmd.Run()
+		cmd_err := cmd.Wait()
+		if cgroup != "" {
+			os.Remove(cgroup)
+		}
 		exitStatus := 0
 		if exiterr, ok := cmd_err.(*exec.ExitError); ok {
 			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
 				exitStatus = status.ExitStatus()
-				log.Printf("Exit Status: %d", exitStatus)
+				cwl.Debugf("Exit Status: %d", exitStatus)
 			}
 		} else {
-			log.Printf("cmd.Run: %v", cmd_err)
+			cwl.Debugf("cmd.Run: %v", cmd_err)
 		}
 		ioutil.WriteFile(resfile, []byte(fmt.Sprintf("%d", exitStatus)), 0600)
 	}(resFile)
-	return cwl.JSONDict{"resFile": resFile}, nil
+	return cwl.JSONDict{"resFile": resFile, "pid": cmd.Process.Pid}, nil
 }
 
-func (self LocalRunner) GetOutput(prodData cwl.JSONDict) cwl.JSONDict {
-	out := cwl.JSONDict{}
-	path := filepath.Join(self.Workdir, "cwl.output.json")
-	if _, err := os.Stat(path); err == nil {
-		log.Printf("Found cwl.output.json")
-		data, _ := ioutil.ReadFile(path)
-		err := yaml.Unmarshal(data, &out)
-		log.Printf("Returned: %s = %s %s", data, out, err)
-	}
-	return out
+func (self LocalRunner) GetOutput(prodData cwl.JSONDict) (cwl.JSONDict, bool) {
+	return readOutputFile(filepath.Join(self.Workdir, "cwl.output.json"))
 }
 
 func (self LocalRunner) ExitCode(procData cwl.JSONDict) (int, bool) {
-	if _, err := os.Stat(procData["resFile"].(string)); err == nil {
-		d, _ := ioutil.ReadFile(procData["resFile"].(string))
-		i, _ := strconv.Atoi(string(d))
-		return i, true
+	return readExitCode(procData["resFile"].(string))
+}
+
+func (self LocalRunner) Kill(procData cwl.JSONDict) error {
+	pid, ok := procData["pid"].(int)
+	if !ok {
+		return fmt.Errorf("No process to kill")
 	}
-	return 0, false
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+func (self LocalRunner) GetHostWorkDir() string {
+	return self.Workdir
+}
+
+func (self LocalRunner) Cleanup() error {
+	os.Remove(self.Workdir + ".result")
+	os.RemoveAll(self.Workdir + LITERALS_SUFFIX)
+	os.RemoveAll(self.Tmpdir)
+	return os.RemoveAll(self.Workdir)
 }
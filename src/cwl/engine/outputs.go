@@ -0,0 +1,194 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputStager moves or copies the files of an output object into the
+// output directory, remembering where every source went so that a file
+// referenced twice is only staged once
+type outputStager struct {
+	outdir   string
+	workDirs []string
+	staged   map[string]string
+	taken    map[string]bool
+	//every location in the output object, a file inside another one is
+	//copied so the enclosing directory stays whole whichever comes first
+	locations []string
+}
+
+// StageOutputs places every File and Directory in outputs under outdir and
+// returns the output object with location and path rewritten. Files that
+// live in one of workDirs are moved, anything else (inputs passed through,
+// cache entries) is copied so the original stays in place.
+func StageOutputs(outputs cwl.JSONDict, outdir string, workDirs []string) (cwl.JSONDict, error) {
+	outdir, err := filepath.Abs(outdir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(outdir, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create outdir: %s", err)
+	}
+	stager := outputStager{outdir: outdir, workDirs: workDirs, staged: map[string]string{}, taken: map[string]bool{}, locations: fileLocations(outputs)}
+	out := cwl.JSONDict{}
+	for k, v := range outputs {
+		o, err := stager.stage(v)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to stage output %s: %s", k, err)
+		}
+		out[k] = o
+	}
+	return out, nil
+}
+
+func (self *outputStager) stage(x interface{}) (interface{}, error) {
+	if base, ok := x.(cwl.JSONDict); ok {
+		x = map[interface{}]interface{}(base)
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if class, ok := base["class"]; ok && (class == "File" || class == "Directory") {
+			return self.stageFile(base)
+		}
+		out := map[interface{}]interface{}{}
+		for k, v := range base {
+			o, err := self.stage(v)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = o
+		}
+		return out, nil
+	} else if base, ok := x.([]interface{}); ok {
+		out := []interface{}{}
+		for _, v := range base {
+			o, err := self.stage(v)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, o)
+		}
+		return out, nil
+	}
+	return x, nil
+}
+
+func (self *outputStager) stageFile(base map[interface{}]interface{}) (interface{}, error) {
+	out := map[interface{}]interface{}{}
+	for k, v := range base {
+		out[k] = v
+	}
+	loc, ok := base["location"].(string)
+	if !ok {
+		return out, nil
+	}
+	src := strings.TrimPrefix(loc, "file://")
+	dst, ok := self.staged[src]
+	if !ok {
+		name := filepath.Base(src)
+		if b, ok := base["basename"].(string); ok && b != "" {
+			name = b
+		}
+		dst = self.freeName(name)
+		if err := self.place(self.resolve(src), dst); err != nil {
+			return nil, err
+		}
+		self.staged[src] = dst
+	}
+	out["location"] = dst
+	out["path"] = dst
+	out["dirname"] = filepath.Dir(dst)
+	//renamed to avoid a collision
+	if out["basename"] != filepath.Base(dst) {
+		out["basename"] = filepath.Base(dst)
+		if base["class"] == "File" {
+			out["nameroot"], out["nameext"] = FileNameSplit(dst)
+		}
+	}
+	//nested listings are already in place, only their locations change
+	if listing, ok := base["listing"].([]interface{}); ok {
+		out["listing"] = relocate(listing, src, dst)
+	}
+	if secondary, ok := base["secondaryFiles"]; ok {
+		s, err := self.stage(secondary)
+		if err != nil {
+			return nil, err
+		}
+		out["secondaryFiles"] = s
+	}
+	return out, nil
+}
+
+// resolve finds where src is now, if it is inside a directory that was
+// already moved to the outdir
+func (self *outputStager) resolve(src string) string {
+	for s, d := range self.staged {
+		if rel, err := filepath.Rel(s, src); err == nil && !strings.HasPrefix(rel, "..") && rel != "." {
+			return filepath.Join(d, rel)
+		}
+	}
+	return src
+}
+
+// freeName picks a destination in the outdir for name, adding a counter
+// before the extension if something already has that name
+func (self *outputStager) freeName(name string) string {
+	root, ext := FileNameSplit(name)
+	dst := filepath.Join(self.outdir, name)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(dst); os.IsNotExist(err) && !self.taken[dst] {
+			break
+		}
+		dst = filepath.Join(self.outdir, fmt.Sprintf("%s_%d%s", root, i, ext))
+	}
+	self.taken[dst] = true
+	return dst
+}
+
+func (self *outputStager) place(src, dst string) error {
+	for _, l := range self.locations {
+		if rel, err := filepath.Rel(l, src); err == nil && !strings.HasPrefix(rel, "..") && rel != "." {
+			return copyPath(src, dst)
+		}
+	}
+	for _, w := range self.workDirs {
+		if w != "" && strings.HasPrefix(src, w+string(filepath.Separator)) {
+			if err := os.Rename(src, dst); err == nil {
+				return nil
+			}
+			break
+		}
+	}
+	return copyPath(src, dst)
+}
+
+func relocate(x interface{}, src, dst string) interface{} {
+	if base, ok := x.(cwl.JSONDict); ok {
+		x = map[interface{}]interface{}(base)
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		out := map[interface{}]interface{}{}
+		for k, v := range base {
+			out[k] = relocate(v, src, dst)
+		}
+		if loc, ok := base["location"].(string); ok {
+			loc = strings.TrimPrefix(loc, "file://")
+			if rel, err := filepath.Rel(src, loc); err == nil && !strings.HasPrefix(rel, "..") {
+				out["location"] = filepath.Join(dst, rel)
+				out["path"] = out["location"]
+				out["dirname"] = filepath.Dir(filepath.Join(dst, rel))
+			}
+		}
+		return out
+	} else if base, ok := x.([]interface{}); ok {
+		out := []interface{}{}
+		for _, v := range base {
+			out = append(out, relocate(v, src, dst))
+		}
+		return out
+	}
+	return x
+}
@@ -0,0 +1,46 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a File output inside a Directory output is staged on its own without
+// taking it out of the directory, whichever of them is staged first
+func TestStageNestedOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outputs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	//map order varies between runs
+	for i := 0; i < 20; i++ {
+		work := filepath.Join(dir, "work")
+		outdir := filepath.Join(dir, "out")
+		os.RemoveAll(work)
+		os.RemoveAll(outdir)
+		os.MkdirAll(filepath.Join(work, "d"), 0755)
+		if err := ioutil.WriteFile(filepath.Join(work, "d", "a.txt"), []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		outputs := cwl.JSONDict{
+			"dir":  map[interface{}]interface{}{"class": "Directory", "location": filepath.Join(work, "d")},
+			"file": map[interface{}]interface{}{"class": "File", "location": filepath.Join(work, "d", "a.txt")},
+		}
+		out, err := StageOutputs(outputs, outdir, []string{work})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := out["dir"].(map[interface{}]interface{})["location"].(string)
+		if _, err := os.Stat(filepath.Join(d, "a.txt")); err != nil {
+			t.Fatalf("File missing from the staged directory: %s", err)
+		}
+		f := out["file"].(map[interface{}]interface{})["location"].(string)
+		if data, err := ioutil.ReadFile(f); err != nil || string(data) != "a" {
+			t.Fatalf("Staged file %s: %q %v", f, data, err)
+		}
+	}
+}
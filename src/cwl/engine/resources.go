@@ -0,0 +1,197 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaults of the ResourceRequirement minimums, ram, tmpdir and outdir are
+// in MiB
+const (
+	DEFAULT_CORES  = 1
+	DEFAULT_RAM    = 256
+	DEFAULT_TMPDIR = 1024
+	DEFAULT_OUTDIR = 1024
+)
+
+// JobResources are the resources a job runs with. Limited is set when the
+// tool declares a ResourceRequirement, the process is then held to
+// CoresLimit and RamLimit, its maximums or, without those, its minimums.
+type JobResources struct {
+	Cores      int
+	Ram        int64
+	TmpdirSize int64
+	OutdirSize int64
+	Limited    bool
+	CoresLimit float64
+	RamLimit   int64
+}
+
+// EvaluateResources evaluates a job's ResourceRequirement, once per job:
+// the result is both what the scheduler reserves and what StartJob runs
+// the job with. Its expressions see the job inputs and the runtime outdir
+// and tmpdir.
+func EvaluateResources(job cwl.Job, runner JobRunner) (JobResources, error) {
+	out := JobResources{Cores: DEFAULT_CORES, Ram: DEFAULT_RAM, TmpdirSize: DEFAULT_TMPDIR, OutdirSize: DEFAULT_OUTDIR}
+	req := job.Resources
+	if req == nil {
+		return out, nil
+	}
+	js_eval := cwl.JSEvaluator{Inputs: job.InputData, Runtime: cwl.JSONDict{"outdir": runner.GetWorkDirPath(), "tmpdir": runner.GetTmpDirPath()}}
+	cores, coresLimit, err := resourceRange("cores", req.CoresMin, req.CoresMax, DEFAULT_CORES, js_eval)
+	if err != nil {
+		return out, err
+	}
+	ram, ramLimit, err := resourceRange("ram", req.RamMin, req.RamMax, DEFAULT_RAM, js_eval)
+	if err != nil {
+		return out, err
+	}
+	tmpdir, _, err := resourceRange("tmpdir", req.TmpdirMin, req.TmpdirMax, DEFAULT_TMPDIR, js_eval)
+	if err != nil {
+		return out, err
+	}
+	outdir, _, err := resourceRange("outdir", req.OutdirMin, req.OutdirMax, DEFAULT_OUTDIR, js_eval)
+	if err != nil {
+		return out, err
+	}
+	out.Cores = int(math.Ceil(cores))
+	out.Ram = int64(math.Ceil(ram))
+	out.TmpdirSize = int64(math.Ceil(tmpdir))
+	out.OutdirSize = int64(math.Ceil(outdir))
+	out.Limited = true
+	out.CoresLimit = coresLimit
+	out.RamLimit = int64(math.Ceil(ramLimit))
+	return out, nil
+}
+
+// resourceRange evaluates a min/max pair, returning the amount a job gets,
+// which is the minimum, and the most it may use
+func resourceRange(name, minExpr, maxExpr string, def float64, js_eval cwl.JSEvaluator) (float64, float64, error) {
+	min, hasMin, err := resourceValue(minExpr, js_eval)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%sMin: %s", name, err)
+	}
+	max, hasMax, err := resourceValue(maxExpr, js_eval)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%sMax: %s", name, err)
+	}
+	if !hasMin {
+		min = def
+		if hasMax {
+			min = max
+		}
+	}
+	if !hasMax {
+		return min, min, nil
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("%sMin %v is larger than %sMax %v", name, min, name, max)
+	}
+	return min, max, nil
+}
+
+func resourceValue(expr string, js_eval cwl.JSEvaluator) (float64, bool, error) {
+	if expr == "" {
+		return 0, false, nil
+	}
+	v, err := js_eval.EvaluateExpression(expr, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	var out float64
+	switch x := v.(type) {
+	case nil:
+		return 0, false, nil
+	case int:
+		out = float64(x)
+	case int64:
+		out = float64(x)
+	case float64:
+		out = x
+	case string:
+		out, err = strconv.ParseFloat(x, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("%s is not a number", x)
+		}
+	default:
+		return 0, false, fmt.Errorf("%s is not a number", cwl.JSValueString(v))
+	}
+	if out < 0 {
+		return 0, false, fmt.Errorf("%v is negative", out)
+	}
+	return out, true, nil
+}
+
+// limitCommand wraps a local command in a shell that moves itself into
+// cgroup, when there is one, and caps its address space at the RAM limit
+// before running it. Unlike the data segment, the address space includes
+// mmap'd memory.
+func limitCommand(cmd_args []string, resources JobResources, cgroup string) []string {
+	script := ""
+	if cgroup != "" {
+		script += fmt.Sprintf("echo $$ > %s && ", cwl.ShellQuote(filepath.Join(cgroup, "cgroup.procs")))
+	}
+	if resources.RamLimit > 0 {
+		script += fmt.Sprintf("ulimit -v %d && ", resources.RamLimit*1024)
+	}
+	script += `exec "$@"`
+	return append([]string{"/bin/sh", "-c", script, "sh"}, cmd_args...)
+}
+
+// createCgroup makes a cgroup v2 group holding a job to its cores and
+// memory, below the group the engine runs in. It fails where the group
+// can't be created or limited, which requires delegated cgroups.
+func createCgroup(name string, resources JobResources) (string, error) {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	parent := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			parent = filepath.Join("/sys/fs/cgroup", strings.TrimPrefix(line, "0::"))
+		}
+	}
+	//on hybrid hosts /sys/fs/cgroup holds v1 controllers, not a group
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); parent == "" || err != nil {
+		return "", fmt.Errorf("No cgroup v2 hierarchy")
+	}
+	dir := filepath.Join(parent, "cwl-"+filepath.Base(name))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", fmt.Errorf("Unable to create cgroup: %s", err)
+	}
+	limits := map[string]string{}
+	if resources.CoresLimit > 0 {
+		limits["cpu.max"] = fmt.Sprintf("%d 100000", int64(resources.CoresLimit*100000))
+	}
+	if resources.RamLimit > 0 {
+		limits["memory.max"] = fmt.Sprintf("%d", resources.RamLimit*1024*1024)
+	}
+	for k, v := range limits {
+		if err := writeCgroupFile(filepath.Join(dir, k), v); err != nil {
+			os.Remove(dir)
+			return "", fmt.Errorf("Unable to set cgroup %s: %s", k, err)
+		}
+	}
+	return dir, nil
+}
+
+// writeCgroupFile sets a control file, which the kernel creates with the
+// group, it is never created here
+func writeCgroupFile(path string, value string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(value)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
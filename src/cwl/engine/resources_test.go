@@ -0,0 +1,99 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestLimitCommandAddressSpace(t *testing.T) {
+	args := limitCommand([]string{"sh", "-c", "ulimit -v"}, JobResources{RamLimit: 100}, "")
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "102400" {
+		t.Errorf("Address space limit %s KiB, expected 102400", out)
+	}
+}
+
+func TestEvaluateResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runner, err := NewLocalRunner(Config{TmpdirPrefix: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Cleanup()
+
+	res, err := EvaluateResources(cwl.Job{}, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (JobResources{Cores: DEFAULT_CORES, Ram: DEFAULT_RAM, TmpdirSize: DEFAULT_TMPDIR, OutdirSize: DEFAULT_OUTDIR}) {
+		t.Errorf("Resources without a requirement %+v", res)
+	}
+
+	job := cwl.Job{
+		InputData: cwl.JSONDict{"size": 300},
+		Resources: &cwl.ResourceRequirement{CoresMin: "1.5", CoresMax: "2", RamMin: "$(inputs.size)", OutdirMax: "2048"},
+	}
+	res, err = EvaluateResources(job, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := JobResources{Cores: 2, Ram: 300, TmpdirSize: DEFAULT_TMPDIR, OutdirSize: 2048, Limited: true, CoresLimit: 2, RamLimit: 300}
+	if res != expected {
+		t.Errorf("Resources %+v, expected %+v", res, expected)
+	}
+
+	for _, req := range []cwl.ResourceRequirement{
+		{CoresMin: "4", CoresMax: "2"},
+		{RamMin: "-1"},
+		{RamMin: "lots"},
+		{RamMin: "$(inputs.missing.size)"},
+	} {
+		r := req
+		if _, err := EvaluateResources(cwl.Job{InputData: cwl.JSONDict{}, Resources: &r}, runner); err == nil {
+			t.Errorf("Requirement %+v accepted", req)
+		}
+	}
+}
+
+// a job using more memory than its ResourceRequirement allows fails
+func TestRamLimit(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	dir, err := ioutil.TempDir("", "resources_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tool := func(ram int) string {
+		return fmt.Sprintf(`cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  ResourceRequirement: {ramMin: %d}
+baseCommand: [python3, -c, "x = bytearray(200*1024*1024)"]
+inputs: []
+outputs: []
+`, ram)
+	}
+	doc := parseDoc(t, dir, map[string]string{"small.cwl": tool(20), "large.cwl": tool(512)}, "small.cwl")
+	if _, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{}); err == nil {
+		t.Error("Job ran beyond its RAM limit")
+	}
+	doc = parseDoc(t, dir, nil, "large.cwl")
+	if _, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{}); err != nil {
+		t.Errorf("Job within its RAM limit failed: %s", err)
+	}
+}
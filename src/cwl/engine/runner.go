@@ -1,14 +1,18 @@
 package cwl_engine
 
 import (
-	"crypto/sha1"
 	"cwl"
 	"fmt"
+	"gopkg.in/yaml.v2"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -16,26 +20,64 @@ type Config struct {
 	TmpdirPrefix    string
 	Outdir          string
 	Quiet           bool
+	CacheDir        string
+	LeaveTmpdir     bool
+	LeaveOutputs    bool
+	//host environment variables passed on to jobs, PATH is always kept
+	//for jobs that do not run in a container
+	PreserveEnvironment       []string
+	PreserveEntireEnvironment bool
+	//capacity the scheduler shares between jobs, ram in MiB, 0 detects
+	//it from the machine
+	MaxCores int
+	MaxRam   int64
+	//time limit of jobs without a ToolTimeLimit, 0 is none
+	DefaultTimeout time.Duration
+	//let tools that don't declare NetworkAccess reach the network, as
+	//v1.0 tools could. They are isolated otherwise.
+	DefaultNetworkAccess bool
 }
 
 type TaskRecord struct {
-	ProcData cwl.JSONDict
-	Inputs   cwl.JSONDict
-	Stderr   string
-	Stdout   string
-	Workdir  string
-	Job      cwl.Job
+	ProcData  cwl.JSONDict
+	Inputs    cwl.JSONDict
+	Stderr    string
+	Stdout    string
+	Workdir   string
+	CmdArgs   []string
+	Job       cwl.Job
+	Cache     *JobCache
+	CacheKey  string
+	Cached    cwl.JSONDict
+	Resources JobResources
+	Log       *cwl.Logger
 }
 
 type PathMapper interface {
 	MapFile(map[interface{}]interface{}) map[interface{}]interface{}
 }
 
+// ProcessOptions are what a job's process is run with besides its command
+// line and streams
+type ProcessOptions struct {
+	DockerImage   string
+	Env           map[string]string
+	Resources     JobResources
+	NetworkAccess bool
+}
+
 type JobRunner interface {
-	StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin, dockerImage string) (cwl.JSONDict, error)
+	StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin string, options ProcessOptions) (cwl.JSONDict, error)
 	ExitCode(prodData cwl.JSONDict) (int, bool)
-	GetOutput(prodData cwl.JSONDict) cwl.JSONDict
+	Kill(procData cwl.JSONDict) error
+	//GetOutput returns the outputs the job wrote to cwl.output.json and
+	//whether it wrote the file
+	GetOutput(prodData cwl.JSONDict) (cwl.JSONDict, bool)
 	GetWorkDirPath() string
+	GetTmpDirPath() string
+	LocationToPath(location string) string
+	GetHostWorkDir() string
+	Cleanup() error
 	Glob(path string) []string
 	ReadFile(path string) ([]byte, error)
 }
@@ -55,31 +97,38 @@ func FileNameSplit(path string) (string, string) {
 
 type RuntimeMapper struct {
 	Runner JobRunner
+	//Log defaults to cwl.Logging
+	Log *cwl.Logger
 }
 
-
 func (self RuntimeMapper) MapFile(in map[interface{}]interface{}) map[interface{}]interface{} {
-	out := in
-	if in["class"].(string) == "File" {
-		root, ext := FileNameSplit(in["path"].(string))
-		out["nameroot"] = root
-		out["nameext"] = ext
-		out["basename"] = filepath.Base(in["path"].(string))
-		if b, ok := in["loadContents"]; ok {
-			if b.(bool) {
-				out["contents"], _ = self.Runner.ReadFile(in["location"].(string))
-				log.Printf("Load Contents: %s", in["location"].(string))
+	var toPath func(string) string
+	if self.Runner != nil {
+		toPath = self.Runner.LocationToPath
+	}
+	out, err := NormalizeFile(in, "", toPath)
+	if err != nil {
+		jobLogger(self.Log).Warnf("Unable to read input %s: %s", out["location"], err)
+	}
+	if b, ok := in["loadContents"].(bool); ok && b && out["class"] == "File" {
+		if loc, ok := out["location"].(string); ok {
+			out["contents"], err = readContents(loc)
+			if err != nil {
+				jobLogger(self.Log).Warnf("Unable to load contents of %s: %s", loc, err)
 			}
+			jobLogger(self.Log).Debugf("Load Contents: %s", loc)
 		}
 	}
 	return out
 }
 
-
 func mapInputs(x interface{}, mapper PathMapper) interface{} {
+	if base, ok := x.(cwl.JSONDict); ok {
+		return cwl.JSONDict(mapInputs(map[interface{}]interface{}(base), mapper).(map[interface{}]interface{}))
+	}
 	if base, ok := x.(map[interface{}]interface{}); ok {
 		if classBase, ok := base["class"]; ok {
-			if (classBase == "File" || classBase == "Directory") && len(base["location"].(string)) > 0 {
+			if classBase == "File" || classBase == "Directory" {
 				return mapper.MapFile(base)
 			}
 		}
@@ -87,6 +136,7 @@ func mapInputs(x interface{}, mapper PathMapper) interface{} {
 		for k, v := range base {
 			out[k] = mapInputs(v, mapper)
 		}
+		return out
 	}
 
 	if base, ok := x.([]interface{}); ok {
@@ -107,49 +157,110 @@ func MapInputs(inputs cwl.JSONDict, mapper PathMapper) cwl.JSONDict {
 	return out
 }
 
-func StartJob(job cwl.Job, runner JobRunner, pathMapper PathMapper) (TaskRecord, error) {
-
-	log.Printf("Command Args: %#v", job.Cmd)
-	log.Printf("Command Files: %#v", job.GetFiles())
-	log.Printf("Command Inputs: %#v", job.InputData)
-	log.Printf("Command Outputs: %#v", job.Outputs)
+// StartJob stages the inputs of job and starts it with the resources
+// EvaluateResources returned for it. It logs to logger, cwl.Logging when
+// nil.
+func StartJob(job cwl.Job, runner JobRunner, pathMapper PathMapper, cache *JobCache, resources JobResources, logger *cwl.Logger) (TaskRecord, error) {
+	logger = jobLogger(logger)
+	logger.Debugf("Command Args: %#v", job.Cmd)
+	logger.Debugf("Command Files: %#v", job.GetFiles())
+	logger.Debugf("Command Inputs: %#v", job.InputData)
+	logger.Debugf("Command Outputs: %#v", job.Outputs)
 
 	input_data := job.InputData
+	//write File and Directory literals to disk before anything refers to
+	//their paths
+	if job.JobType == cwl.COMMAND {
+		literals := runner.GetHostWorkDir() + LITERALS_SUFFIX
+		if err := stageJobLiterals(job.Cmd, literals); err != nil {
+			return TaskRecord{}, fmt.Errorf("Unable to write literal: %s", err)
+		}
+		staged, err := StageLiterals(input_data, literals)
+		if err != nil {
+			return TaskRecord{}, fmt.Errorf("Unable to write literal: %s", err)
+		}
+		input_data = staged.(cwl.JSONDict)
+	}
 	//attempting to get input files not mentioned in the user request, ie
 	//default files. Not sure if this covers all cases
 	for _, i := range job.GetFiles() {
 		if !i.Output {
 			if i.Id != "" {
 				//if _, ok := input_data[i.Id]; !ok {
-				log.Printf("Translating %s %#v", i.Id, i)
-				input_data[i.Id] = map[interface{}]interface{}{
-					"class":        "File",
-					"location":     i.Location,
-					"path":         i.Path,
-					"loadContents": i.LoadContents,
-				}
+				logger.Debugf("Translating %s %#v", i.Id, i)
+				f := map[interface{}]interface{}(i.ToJSONDict())
+				f["path"] = i.Path
+				input_data[i.Id] = f
 				//}
 			}
 		}
 	}
-	log.Printf("Translated Input: %s", input_data)
-	runtimeMapper := RuntimeMapper{Runner:runner}
+	//add the companions named by secondaryFiles patterns to input files
+	sf_eval := cwl.JSEvaluator{Inputs: input_data, Runtime: jobRuntime(runner, resources)}
+	for k, schema := range job.Inputs {
+		if v, ok := input_data[k]; ok && v != nil {
+			o, err := addSecondaryFiles(v, schema, sf_eval, true)
+			if err != nil {
+				return TaskRecord{}, fmt.Errorf("Input %s: %s", k, err)
+			}
+			input_data[k] = o
+		}
+	}
+	//list Directory inputs
+	for k, schema := range job.Inputs {
+		if v, ok := input_data[k]; ok && v != nil {
+			loadListing := schema.LoadListing
+			if loadListing == "" {
+				loadListing = job.LoadListing
+			}
+			o, err := addListings(v, loadListing)
+			if err != nil {
+				return TaskRecord{}, fmt.Errorf("Input %s: %s", k, err)
+			}
+			input_data[k] = o
+		}
+	}
+	logger.Debugf("Translated Input: %s", input_data)
+	runtimeMapper := RuntimeMapper{Runner: runner}
 	//get the inputs using the path mapper from the job runner
 	inputs := MapInputs(input_data, runtimeMapper)
-	log.Printf("Mapped Inputs: %s", inputs)
-	js_eval := cwl.JSEvaluator{Inputs: inputs}
+	logger.Debugf("Mapped Inputs: %s", inputs)
+	js_eval := cwl.JSEvaluator{Inputs: inputs, Runtime: jobRuntime(runner, resources)}
+	//stage the InitialWorkDirRequirement listing, inputs placed in the
+	//work directory are seen at their new path from then on
+	if job.JobType == cwl.COMMAND && (len(job.WorkDir.Listing) > 0 || job.WorkDir.Expression != "") {
+		staged, err := stageInitialWorkDir(job.WorkDir, js_eval, runner.GetHostWorkDir(), runner.GetWorkDirPath())
+		if err != nil {
+			return TaskRecord{}, err
+		}
+		if len(staged) > 0 {
+			pathMapper = workDirMapper{mapper: pathMapper, staged: staged}
+			inputs = MapInputs(input_data, workDirMapper{mapper: runtimeMapper, staged: staged})
+			js_eval.Inputs = inputs
+		}
+	}
 	//process command line arguments
 	cmd_args := []string{}
 	if job.JobType == cwl.COMMAND {
+		argMapper := func(x interface{}) interface{} {
+			return mapInputs(x, pathMapper)
+		}
 		for i := range job.Cmd {
-			s, err := job.Cmd[i].GetArgs(js_eval, func(x interface{}) interface{} {
-				return mapInputs(x, pathMapper)
-			})
+			var s []string
+			var err error
+			if job.ShellCommand {
+				s, err = job.Cmd[i].GetShellArgs(js_eval, argMapper)
+			} else {
+				s, err = job.Cmd[i].GetArgs(js_eval, argMapper)
+			}
 			if err != nil {
 				return TaskRecord{}, fmt.Errorf("Expression Eval failed: %s", err)
 			}
 			cmd_args = append(cmd_args, s...)
 		}
+		if job.ShellCommand {
+			cmd_args = []string{"/bin/sh", "-c", strings.Join(cmd_args, " ")}
+		}
 	} else if job.JobType == cwl.EXPRESSION {
 		cmd_args = append(cmd_args, job.Expression)
 		js_inputs := cwl.JSONDict{}
@@ -161,9 +272,9 @@ func StartJob(job cwl.Job, runner JobRunner, pathMapper PathMapper) (TaskRecord,
 			js_inputs[job.Cmd[i].Id] = s
 		}
 		inputs = MapInputs(js_inputs, pathMapper)
-		log.Printf("Expression Conversion: %s", inputs)
+		logger.Debugf("Expression Conversion: %s", inputs)
 	}
-	log.Printf("CMD: %s", cmd_args)
+	logger.Debugf("CMD: %s", cmd_args)
 
 	stdout := ""
 	stderr := ""
@@ -175,6 +286,9 @@ func StartJob(job cwl.Job, runner JobRunner, pathMapper PathMapper) (TaskRecord,
 		if err != nil {
 			return TaskRecord{}, err
 		}
+		if err := checkStreamName(stdout); err != nil {
+			return TaskRecord{}, fmt.Errorf("Invalid stdout: %s", err)
+		}
 	}
 	if job.Stderr != "" {
 		var err error
@@ -182,6 +296,9 @@ func StartJob(job cwl.Job, runner JobRunner, pathMapper PathMapper) (TaskRecord,
 		if err != nil {
 			return TaskRecord{}, err
 		}
+		if err := checkStreamName(stderr); err != nil {
+			return TaskRecord{}, fmt.Errorf("Invalid stderr: %s", err)
+		}
 	}
 	if job.Stdin != "" {
 		var err error
@@ -189,52 +306,404 @@ func StartJob(job cwl.Job, runner JobRunner, pathMapper PathMapper) (TaskRecord,
 		if err != nil {
 			return TaskRecord{}, err
 		}
+		if !filepath.IsAbs(stdin) {
+			stdin = filepath.Join(workdir, stdin)
+		}
+	}
+	//stdout and stderr may be written to subdirectories of the output dir
+	for _, name := range []string{stdout, stderr} {
+		if name != "" {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(runner.GetHostWorkDir(), name)), 0755); err != nil {
+				return TaskRecord{}, err
+			}
+		}
 	}
 
-	proc_data, err := runner.StartProcess(inputs, cmd_args, workdir, stdout, stderr, stdin, job.DockerImage)
-	return TaskRecord{ProcData: proc_data, Workdir: workdir, Inputs: inputs, Stdout: stdout, Stderr: stderr, Job: job}, err
+	env, err := jobEnvironment(job, js_eval, runner)
+	if err != nil {
+		return TaskRecord{}, err
+	}
+	networkAccess := false
+	if job.NetworkAccess != "" {
+		e, err := js_eval.EvaluateExpressionString(job.NetworkAccess, nil)
+		if err != nil {
+			return TaskRecord{}, fmt.Errorf("NetworkAccess %s", err)
+		}
+		networkAccess = e == "true"
+	}
+	options := ProcessOptions{DockerImage: job.DockerImage, Env: env, Resources: resources, NetworkAccess: networkAccess}
+
+	cacheKey := ""
+	if cache != nil && job.JobType == cwl.COMMAND {
+		reuse := true
+		if job.EnableReuse != "" {
+			e, err := js_eval.EvaluateExpressionString(job.EnableReuse, nil)
+			if err != nil {
+				return TaskRecord{}, err
+			}
+			reuse = e != "false"
+		}
+		if reuse {
+			var err error
+			staged := []string{runner.GetHostWorkDir(), runner.GetHostWorkDir() + LITERALS_SUFFIX}
+			cacheKey, err = cache.Key(job, cmd_args, stdout, stderr, stdin, inputs, options, staged)
+			if err != nil {
+				logger.Warnf("Unable to build cache key: %s", err)
+			} else if out, ok := cache.Get(cacheKey); ok {
+				//entries are checked against the schema the tool has now
+				if err := ValidateOutputs(out, job.Outputs, ""); err != nil {
+					logger.Warnf("Cache entry %s not valid, running job: %s", cacheKey, err)
+				} else {
+					logger.Infof("Cache hit: %s", cacheKey)
+					return TaskRecord{Workdir: workdir, Inputs: inputs, CmdArgs: cmd_args, Job: job, Cached: out, Log: logger}, nil
+				}
+			}
+		} else {
+			logger.Debugf("Work reuse disabled")
+		}
+	}
+
+	proc_data, err := runner.StartProcess(inputs, cmd_args, workdir, stdout, stderr, stdin, options)
+	out := TaskRecord{ProcData: proc_data, Workdir: workdir, Inputs: inputs, Stdout: stdout, Stderr: stderr, CmdArgs: cmd_args, Job: job, Resources: resources, Log: logger}
+	if cacheKey != "" {
+		out.Cache = cache
+		out.CacheKey = cacheKey
+	}
+	return out, err
+}
+
+// jobLogger returns the logger of a job, the package wide one when it has
+// none
+func jobLogger(logger *cwl.Logger) *cwl.Logger {
+	if logger == nil {
+		return cwl.Logging
+	}
+	return logger
+}
+
+// readExitCode reads the exit status a runner wrote to resFile. The job
+// isn't done until the file holds a number, it is created before the
+// status is written.
+func readExitCode(resFile string) (int, bool) {
+	d, err := ioutil.ReadFile(resFile)
+	if err != nil {
+		return 0, false
+	}
+	i, err := strconv.Atoi(string(d))
+	if err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// readOutputFile reads the cwl.output.json at path, which the job may not
+// have written
+func readOutputFile(path string) (cwl.JSONDict, bool) {
+	out := cwl.JSONDict{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return out, false
+	}
+	cwl.Debugf("Found cwl.output.json")
+	err = yaml.Unmarshal(data, &out)
+	cwl.Debugf("Returned: %s = %s %s", data, out, err)
+	return out, true
+}
+
+// openStreams connects cmd to the stdout and stderr files it creates in dir
+// and to the stdin file. The files opened so far are returned even on error,
+// the caller closes them once cmd has started.
+func openStreams(cmd *exec.Cmd, dir, stdout, stderr, stdin string) ([]*os.File, error) {
+	files := []*os.File{}
+	if stdout != "" {
+		f, err := os.Create(filepath.Join(dir, stdout))
+		if err != nil {
+			return files, err
+		}
+		files = append(files, f)
+		cmd.Stdout = f
+	}
+	if stderr != "" {
+		f, err := os.Create(filepath.Join(dir, stderr))
+		if err != nil {
+			return files, err
+		}
+		files = append(files, f)
+		cmd.Stderr = f
+	}
+	if stdin != "" {
+		f, err := os.Open(stdin)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, f)
+		cmd.Stdin = f
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func JobSucceeded(task_data TaskRecord, runner JobRunner) bool {
+	if task_data.Cached != nil {
+		return true
+	}
+	code, done := runner.ExitCode(task_data.ProcData)
+	if !done {
+		return false
+	}
+	if len(task_data.Job.SuccessCodes) == 0 {
+		return code == 0
+	}
+	for _, i := range task_data.Job.SuccessCodes {
+		if i == code {
+			return true
+		}
+	}
+	return false
 }
 
 func CleanupJob(task_data TaskRecord, runner JobRunner) (cwl.JSONDict, error) {
-	out := runner.GetOutput(task_data.ProcData)
-
-	js_eval := cwl.JSEvaluator{Inputs: task_data.Inputs}
-
-	out_files := cwl.JSONDict{}
-	for _, o := range task_data.Job.GetFiles() {
-		if o.Output {
-			if o.Glob != "" {
-				glob, _ := js_eval.EvaluateExpressionString(o.Glob, nil)
-				log.Printf("Output File Glob: %s", glob)
-				g := runner.Glob(glob)
-				for _, p := range g {
-					log.Printf("Found %s %s", o.Id, p)
-					hasher := sha1.New()
-					file, _ := os.Open(p)
-					if _, err := io.Copy(hasher, file); err != nil {
-						log.Fatal(err)
-					}
-					hash_val := fmt.Sprintf("sha1$%x", hasher.Sum([]byte{}))
-					file.Close()
-					info, _ := os.Stat(p)
-					f := map[interface{}]interface{}{"location": p, "checksum": hash_val, "class": "File", "size": info.Size()}
-					out_files[o.Id] = f
+	if task_data.Cached != nil {
+		return task_data.Cached, nil
+	}
+	logger := jobLogger(task_data.Log)
+	out, written := runner.GetOutput(task_data.ProcData)
+
+	js_eval := cwl.JSEvaluator{Inputs: task_data.Inputs, Runtime: jobRuntime(runner, task_data.Resources)}
+
+	//a cwl.output.json written by the tool replaces the output bindings
+	if !written {
+		out = cwl.JSONDict{}
+		for _, o := range task_data.Job.GetFiles() {
+			if o.Output && (len(o.Glob) > 0 || o.OutputEval != "") {
+				v, err := collectOutput(o, task_data.Job.Outputs[o.Id], js_eval, runner, logger)
+				if err != nil {
+					return nil, err
 				}
+				out[o.Id] = v
 			}
 		}
 	}
-	for k, v := range task_data.Job.Outputs {
-		if v.TypeName == "File" || v.TypeName == "stdout" || v.TypeName == "stderr" {
-			if _, ok := out_files[k]; ok {
-				out[k] = out_files[k]
+	if err := ValidateOutputs(out, task_data.Job.Outputs, runner.GetHostWorkDir()); err != nil {
+		return nil, err
+	}
+	for k, schema := range task_data.Job.Outputs {
+		loadListing := schema.LoadListing
+		if loadListing == "" {
+			loadListing = task_data.Job.LoadListing
+		}
+		if v, ok := out[k]; ok && v != nil {
+			o, err := addListings(v, loadListing)
+			if err != nil {
+				return nil, fmt.Errorf("Output %s: %s", k, err)
+			}
+			out[k] = o
+		}
+	}
+	normalized, err := normalizeFiles(out, runner.GetHostWorkDir(), nil)
+	if err != nil {
+		return nil, err
+	}
+	out = cwl.JSONDict(normalized.(map[interface{}]interface{}))
+	for k, schema := range task_data.Job.Outputs {
+		if schema.Format != "" && out[k] != nil {
+			format, err := js_eval.EvaluateExpressionString(schema.Format, nil)
+			if err != nil {
+				return nil, fmt.Errorf("Output %s format error: %s", k, err)
+			}
+			setFormat(out[k], format)
+		}
+	}
+
+	if task_data.Cache != nil && JobSucceeded(task_data, runner) {
+		if err := task_data.Cache.Put(task_data.CacheKey, out); err != nil {
+			logger.Warnf("Unable to cache job outputs: %s", err)
+		}
+	}
+	return out, nil
+}
+
+// globOutput returns the sorted, de-duplicated paths matched by the glob
+// patterns of an output. Patterns may be expressions returning a string or
+// a list of strings.
+func globOutput(o cwl.JobFile, js_eval cwl.JSEvaluator, runner JobRunner, logger *cwl.Logger) ([]string, error) {
+	patterns := []string{}
+	for _, g := range o.Glob {
+		v, err := js_eval.EvaluateExpression(g, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Output %s glob error: %s", o.Id, err)
+		}
+		if s, ok := v.(string); ok {
+			patterns = append(patterns, s)
+		} else if a, ok := v.([]interface{}); ok {
+			for _, i := range a {
+				if s, ok := i.(string); ok {
+					patterns = append(patterns, s)
+				} else {
+					return nil, fmt.Errorf("Output %s glob is not a string: %#v", o.Id, i)
+				}
+			}
+		} else if v != nil {
+			return nil, fmt.Errorf("Output %s glob is not a string: %#v", o.Id, v)
+		}
+	}
+	found := map[string]bool{}
+	matches := []string{}
+	for _, pattern := range patterns {
+		logger.Debugf("Output File Glob: %s", pattern)
+		for _, p := range runner.Glob(pattern) {
+			if !found[p] {
+				found[p] = true
+				matches = append(matches, p)
+			}
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// collectOutput builds the value of an output from its glob matches. With
+// an outputEval the expression gets the list of matched files as self and
+// its result is checked against the schema, otherwise the matches are
+// shaped by the schema: a list for arrays, a single File or Directory
+// otherwise.
+func collectOutput(o cwl.JobFile, schema cwl.Schema, js_eval cwl.JSEvaluator, runner JobRunner, logger *cwl.Logger) (interface{}, error) {
+	matches, err := globOutput(o, js_eval, runner, logger)
+	if err != nil {
+		return nil, err
+	}
+	t := schema.NonNullType()
+	class := "File"
+	if t.TypeName == "Directory" || (t.TypeName == "array" && t.Items != nil && t.Items.NonNullType().TypeName == "Directory") {
+		class = "Directory"
+	}
+	files := []interface{}{}
+	for _, p := range matches {
+		f, err := outputFile(p, class, o.LoadContents)
+		if err != nil {
+			return nil, fmt.Errorf("Output %s: %s", o.Id, err)
+		}
+		if class == "File" {
+			if err := resolveSecondaryFiles(f, schema, js_eval, false); err != nil {
+				return nil, fmt.Errorf("Output %s: %s", o.Id, err)
 			}
 		}
+		files = append(files, f)
+	}
+
+	if o.OutputEval != "" {
+		value, err := js_eval.EvaluateExpression(o.OutputEval, files)
+		if err != nil {
+			return nil, fmt.Errorf("Output %s outputEval error: %s", o.Id, err)
+		}
+		if err := schema.Validate(value); err != nil {
+			return nil, fmt.Errorf("Output %s: %s", o.Id, err)
+		}
+		return value, nil
+	}
+	if t.TypeName == "array" {
+		return files, nil
+	}
+	if len(files) == 0 {
+		if schema.IsOptional() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Output %s: no file matches %s", o.Id, strings.Join(o.Glob, ", "))
+	}
+	if len(files) > 1 {
+		return nil, fmt.Errorf("Output %s: glob matched %d files, expected one", o.Id, len(files))
 	}
+	return files[0], nil
+}
 
+func outputFile(p string, class string, loadContents bool) (map[interface{}]interface{}, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if class == "Directory" && !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", p)
+	}
+	if class == "File" && info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", p)
+	}
+	out, err := NormalizeFile(map[interface{}]interface{}{"class": class, "location": p}, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if loadContents && class == "File" {
+		contents, err := readContents(p)
+		if err != nil {
+			return nil, err
+		}
+		out["contents"] = contents
+	}
 	return out, nil
 }
 
+// setFormat sets the format of the Files in x that do not have one
+func setFormat(x interface{}, format string) {
+	if base, ok := x.(map[interface{}]interface{}); ok && base["class"] == "File" {
+		if _, ok := base["format"]; !ok {
+			base["format"] = format
+		}
+	} else if base, ok := x.([]interface{}); ok {
+		for _, i := range base {
+			setFormat(i, format)
+		}
+	}
+}
+
+// CONTENTS_LIMIT is the number of bytes loadContents reads from a file
+const CONTENTS_LIMIT = 64 * 1024
+
+func readContents(p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, CONTENTS_LIMIT))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// checkStreamName rejects stdout and stderr names that place the file
+// outside the output directory
+func checkStreamName(name string) error {
+	if filepath.IsAbs(name) {
+		return fmt.Errorf("%s is an absolute path", name)
+	}
+	clean := filepath.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("%s is not a file in the output directory", name)
+	}
+	return nil
+}
+
+// jobRuntime is the runtime object expressions of a job can see
+func jobRuntime(runner JobRunner, resources JobResources) cwl.JSONDict {
+	return cwl.JSONDict{
+		"outdir":     runner.GetWorkDirPath(),
+		"tmpdir":     runner.GetTmpDirPath(),
+		"cores":      resources.Cores,
+		"ram":        resources.Ram,
+		"outdirSize": resources.OutdirSize,
+		"tmpdirSize": resources.TmpdirSize,
+	}
+}
+
 func JobDone(task_data TaskRecord, runner JobRunner) bool {
+	if task_data.Cached != nil {
+		return true
+	}
 	_, done := runner.ExitCode(task_data.ProcData)
 	return done
 }
@@ -0,0 +1,291 @@
+package cwl_engine
+
+import (
+	"bytes"
+	"cwl"
+	"encoding/json"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadExitCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	resFile := filepath.Join(dir, "job.result")
+
+	if _, done := readExitCode(resFile); done {
+		t.Error("Job done without a result file")
+	}
+	//the runner has created the file but not written the status yet
+	ioutil.WriteFile(resFile, []byte{}, 0600)
+	if code, done := readExitCode(resFile); done {
+		t.Errorf("Job done with exit code %d from an empty result file", code)
+	}
+	ioutil.WriteFile(resFile, []byte("1"), 0600)
+	if code, done := readExitCode(resFile); !done || code != 1 {
+		t.Errorf("Exit code %d done %v, expected 1", code, done)
+	}
+}
+
+func TestNumberOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  InlineJavascriptRequirement: {}
+baseCommand: "true"
+inputs:
+  x: int
+outputs:
+  half:
+    type: float
+    outputBinding: {outputEval: $(inputs.x / 2)}
+  whole:
+    type: double
+    outputBinding: {outputEval: $(inputs.x / 1.0)}
+  big:
+    type: long
+    outputBinding:
+      outputEval: $(Math.pow(inputs.x, 12))
+`}, "tool.cwl")
+
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{"x": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["half"] != 3.5 {
+		t.Errorf("float output %#v", out["half"])
+	}
+	if out["whole"] != 7 {
+		t.Errorf("Integral double output %#v", out["whole"])
+	}
+	if out["big"] != 13841287201 {
+		t.Errorf("long output %#v", out["big"])
+	}
+}
+
+// optionsRunner records the ProcessOptions its jobs are started with
+type optionsRunner struct {
+	JobRunner
+	options *ProcessOptions
+}
+
+func (self optionsRunner) StartProcess(inputs cwl.JSONDict, cmd_args []string, workdir, stdout, stderr, stdin string, options ProcessOptions) (cwl.JSONDict, error) {
+	*self.options = options
+	return self.JobRunner.StartProcess(inputs, cmd_args, workdir, stdout, stderr, stdin, options)
+}
+
+func TestNetworkAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tool := shellTool("true")
+	files := map[string]string{
+		"tool.cwl":    tool,
+		"network.cwl": tool + "requirements:\n  NetworkAccess: {networkAccess: true}\n",
+		"closed.cwl":  tool + "requirements:\n  NetworkAccess: {networkAccess: false}\n",
+	}
+	for _, c := range []struct {
+		main          string
+		defaultAccess bool
+		expected      bool
+	}{
+		{"tool.cwl", false, false},
+		{"tool.cwl", true, true},
+		{"network.cwl", false, true},
+		{"closed.cwl", true, false},
+	} {
+		doc := parseDoc(t, dir, files, c.main)
+		options := ProcessOptions{}
+		factory := func(job cwl.Job, config Config) (JobRunner, error) {
+			runner, err := DefaultRunnerFactory(job, config)
+			return optionsRunner{runner, &options}, err
+		}
+		exec, err := NewExecutor(Config{TmpdirPrefix: dir, TmpOutdirPrefix: dir, MaxCores: 1, MaxRam: 1024, DefaultNetworkAccess: c.defaultAccess}, factory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := exec.Run(context.Background(), doc, cwl.JSONDict{"x": "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if options.NetworkAccess != c.expected {
+			t.Errorf("%s with default network access %v: network access %v", c.main, c.defaultAccess, options.NetworkAccess)
+		}
+	}
+}
+
+// the records StartJob and CleanupJob log carry the step and job ids
+func TestJobLogFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": shellTool("true")}, "tool.cwl")
+	exec := testExecutor(t, dir, 1)
+	out := &bytes.Buffer{}
+	exec.Log = cwl.NewLogger(nil, cwl.ERROR)
+	exec.Log.SetJSONOutput(out, cwl.DEBUG)
+	if _, _, err := exec.Run(context.Background(), doc, cwl.JSONDict{"x": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		r := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(l), &r); err != nil {
+			t.Fatal(err)
+		}
+		if msg, _ := r["msg"].(string); strings.HasPrefix(msg, "CMD: ") {
+			found = true
+			if r[cwl.LOG_STEP_ID] == nil || r[cwl.LOG_JOB_ID] == nil {
+				t.Errorf("StartJob record without step or job id: %v", r)
+			}
+		}
+	}
+	if !found {
+		t.Error("StartJob logged no command line")
+	}
+}
+
+func TestOutputBindingContents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  InlineJavascriptRequirement: {}
+baseCommand: [sh, -c, "echo 42 > n.txt"]
+inputs: []
+outputs:
+  number:
+    type: int
+    outputBinding:
+      glob: n.txt
+      loadContents: true
+      outputEval: $(parseInt(self[0].contents))
+  file:
+    type: File
+    outputBinding:
+      glob: n.txt
+      loadContents: true
+  names:
+    type:
+      type: array
+      items: string
+    outputBinding:
+      glob: "*.txt"
+      outputEval: $(self.map(function(f) { return f.basename }))
+`}, "tool.cwl")
+
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["number"] != 42 {
+		t.Errorf("outputEval of the loaded contents %#v", out["number"])
+	}
+	if f, ok := out["file"].(map[interface{}]interface{}); !ok || f["contents"] != "42\n" {
+		t.Errorf("File output with loadContents %#v", out["file"])
+	}
+	if !reflect.DeepEqual(out["names"], []interface{}{"n.txt"}) {
+		t.Errorf("outputEval of the glob matches %#v", out["names"])
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	tool := `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  ShellCommandRequirement: {}
+baseCommand: echo
+arguments:
+  - valueFrom: "|"
+    shellQuote: false
+    position: 1
+  - valueFrom: wc -c
+    shellQuote: false
+    position: 2
+inputs:
+  x:
+    type: string
+    inputBinding: {}
+outputs: []
+`
+	got := commandLine(t, tool, cwl.JSONDict{"x": "it's here"})
+	expected := []string{"/bin/sh", "-c", `echo 'it'"'"'s here' | wc -c`}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Command line %q, expected %q", got, expected)
+	}
+}
+
+func TestCheckStreamName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"out.txt":        true,
+		"sub/out.txt":    true,
+		"./out.txt":      true,
+		"/tmp/out.txt":   false,
+		"../out.txt":     false,
+		"sub/../../x":    false,
+		".":              false,
+		"sub/..":         false,
+		"..hidden/x.txt": true,
+	} {
+		if err := checkStreamName(name); (err == nil) != valid {
+			t.Errorf("Stream name %s: %v", name, err)
+		}
+	}
+}
+
+// a stdin input is read by the job, stdout may be written to a
+// subdirectory
+func TestStdinInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "in.txt")
+	if err := ioutil.WriteFile(input, []byte("from stdin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: cat
+stdout: sub/out.txt
+inputs:
+  in: stdin
+outputs:
+  out: stdout
+`}, "tool.cwl")
+
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{"in": map[interface{}]interface{}{"class": "File", "location": input}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := out["out"].(map[interface{}]interface{})
+	if !ok {
+		t.Fatalf("stdout output %#v", out["out"])
+	}
+	//the work directory is gone with the run, the output's checksum
+	//tells what the job read
+	if f["basename"] != "out.txt" || f["checksum"] != "sha1$87cd091506da4bc4034e9efa2e919dc6edb04a42" {
+		t.Errorf("stdout output %v", f)
+	}
+}
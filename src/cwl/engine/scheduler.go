@@ -0,0 +1,106 @@
+package cwl_engine
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Scheduler admits jobs while the cores and RAM (in MiB) they need are free
+// on the machine. A job that doesn't fit waits until running jobs release
+// theirs. Ram is 0 where the machine's memory is unknown, RAM is then not
+// accounted for.
+type Scheduler struct {
+	Cores     int
+	Ram       int64
+	usedCores int
+	usedRam   int64
+	released  chan struct{}
+	sync.Mutex
+}
+
+// NewScheduler returns a Scheduler for the given capacity, cores or ram
+// left at 0 are detected from the machine
+func NewScheduler(cores int, ram int64) *Scheduler {
+	if cores <= 0 {
+		cores = runtime.NumCPU()
+	}
+	if ram <= 0 {
+		ram = hostMemory()
+	}
+	return &Scheduler{Cores: cores, Ram: ram, released: make(chan struct{})}
+}
+
+// Check returns an error if a job needing resources can never be run on
+// the machine
+func (self *Scheduler) Check(resources JobResources) error {
+	if resources.Cores > self.Cores {
+		return fmt.Errorf("Job needs %d cores, only %d are available", resources.Cores, self.Cores)
+	}
+	if self.Ram > 0 && resources.Ram > self.Ram {
+		return fmt.Errorf("Job needs %d MiB of RAM, only %d MiB are available", resources.Ram, self.Ram)
+	}
+	return nil
+}
+
+// Acquire blocks until resources are free and reserves them. It fails at
+// once if they never will be.
+func (self *Scheduler) Acquire(ctx context.Context, resources JobResources) error {
+	if err := self.Check(resources); err != nil {
+		return err
+	}
+	for {
+		self.Lock()
+		if self.usedCores+resources.Cores <= self.Cores && (self.Ram <= 0 || self.usedRam+resources.Ram <= self.Ram) {
+			self.usedCores += resources.Cores
+			self.usedRam += resources.Ram
+			self.Unlock()
+			return nil
+		}
+		released := self.released
+		self.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// Release returns the resources of a finished job and wakes the jobs
+// waiting for them
+func (self *Scheduler) Release(resources JobResources) {
+	self.Lock()
+	self.usedCores -= resources.Cores
+	self.usedRam -= resources.Ram
+	close(self.released)
+	self.released = make(chan struct{})
+	self.Unlock()
+}
+
+// hostMemory returns the total memory of the machine in MiB, or 0 if it
+// can't be read
+func hostMemory() int64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb / 1024
+		}
+	}
+	return 0
+}
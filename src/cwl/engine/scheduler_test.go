@@ -0,0 +1,82 @@
+package cwl_engine
+
+import (
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerCheck(t *testing.T) {
+	s := NewScheduler(4, 1024)
+	if err := s.Check(JobResources{Cores: 4, Ram: 1024}); err != nil {
+		t.Errorf("Job that fits was rejected: %s", err)
+	}
+	if err := s.Check(JobResources{Cores: 5, Ram: 1}); err == nil {
+		t.Errorf("Job needing too many cores was accepted")
+	}
+	if err := s.Check(JobResources{Cores: 1, Ram: 2048}); err == nil {
+		t.Errorf("Job needing too much RAM was accepted")
+	}
+	//unknown memory is not accounted for
+	s = &Scheduler{Cores: 1, released: make(chan struct{})}
+	if err := s.Check(JobResources{Cores: 1, Ram: 1 << 20}); err != nil {
+		t.Errorf("RAM was checked without a known capacity: %s", err)
+	}
+}
+
+func TestSchedulerAcquireRelease(t *testing.T) {
+	s := NewScheduler(2, 1024)
+	job := JobResources{Cores: 1, Ram: 512}
+	for i := 0; i < 2; i++ {
+		if err := s.Acquire(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acquired := make(chan error)
+	go func() {
+		acquired <- s.Acquire(context.Background(), job)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("Job was admitted beyond capacity")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Release(job)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Queued job was not admitted after a release")
+	}
+	s.Release(job)
+	s.Release(job)
+	if s.usedCores != 0 || s.usedRam != 0 {
+		t.Errorf("Resources left in use: %d cores %d MiB", s.usedCores, s.usedRam)
+	}
+}
+
+func TestSchedulerAcquireFails(t *testing.T) {
+	s := NewScheduler(1, 1024)
+	if err := s.Acquire(context.Background(), JobResources{Cores: 2}); err == nil {
+		t.Errorf("Job that can never fit was queued")
+	}
+	if err := s.Acquire(context.Background(), JobResources{Cores: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Acquire(ctx, JobResources{Cores: 1})
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Cancelled Acquire did not return")
+	}
+}
@@ -0,0 +1,199 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secondaryPatterns collects the secondaryFiles patterns of a parameter,
+// which may be declared on the parameter or on its (array) type
+func secondaryPatterns(schema cwl.Schema) []cwl.SecondaryFile {
+	out := append([]cwl.SecondaryFile{}, schema.SecondaryFiles...)
+	for _, t := range schema.Types {
+		out = append(out, secondaryPatterns(t)...)
+	}
+	if schema.Items != nil {
+		out = append(out, secondaryPatterns(*schema.Items)...)
+	}
+	return out
+}
+
+// recordFields collects the fields of the record types of a schema, which
+// may be a record, a union with records or an array of them
+func recordFields(schema cwl.Schema) []cwl.Schema {
+	out := append([]cwl.Schema{}, schema.Fields...)
+	for _, t := range schema.Types {
+		out = append(out, recordFields(t)...)
+	}
+	if schema.Items != nil {
+		out = append(out, recordFields(*schema.Items)...)
+	}
+	return out
+}
+
+// addSecondaryFiles attaches the secondary files of every File in value,
+// which may be a single File, a record or a list of them. The Files of a
+// record get the patterns of their field.
+func addSecondaryFiles(value interface{}, schema cwl.Schema, js_eval cwl.JSEvaluator, defaultRequired bool) (interface{}, error) {
+	if base, ok := value.(cwl.JSONDict); ok {
+		value = map[interface{}]interface{}(base)
+	}
+	if base, ok := value.(map[interface{}]interface{}); ok && base["class"] == "File" {
+		if err := resolveSecondaryFiles(base, schema, js_eval, defaultRequired); err != nil {
+			return nil, err
+		}
+		return base, nil
+	}
+	if base, ok := value.(map[interface{}]interface{}); ok && base["class"] == nil {
+		for _, f := range recordFields(schema) {
+			if v, ok := base[f.Name]; ok && v != nil {
+				o, err := addSecondaryFiles(v, f, js_eval, defaultRequired)
+				if err != nil {
+					return nil, fmt.Errorf("Field %s: %s", f.Name, err)
+				}
+				base[f.Name] = o
+			}
+		}
+		return base, nil
+	}
+	if base, ok := value.([]interface{}); ok {
+		out := []interface{}{}
+		for _, i := range base {
+			o, err := addSecondaryFiles(i, schema, js_eval, defaultRequired)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, o)
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// resolveSecondaryFiles applies the secondaryFiles patterns of schema to
+// the primary File, adding the companions that exist to its
+// secondaryFiles. A required companion that is missing is an error.
+// Companions already listed are kept.
+func resolveSecondaryFiles(primary map[interface{}]interface{}, schema cwl.Schema, js_eval cwl.JSEvaluator, defaultRequired bool) error {
+	patterns := secondaryPatterns(schema)
+	if len(patterns) == 0 {
+		return nil
+	}
+	loc, ok := primary["location"].(string)
+	if !ok {
+		return nil
+	}
+	loc = strings.TrimPrefix(loc, "file://")
+	//expressions see the primary with its name fields, even before the
+	//input is mapped
+	self := map[interface{}]interface{}{}
+	for k, v := range primary {
+		self[k] = v
+	}
+	root, ext := FileNameSplit(loc)
+	self["basename"] = filepath.Base(loc)
+	self["nameroot"] = root
+	self["nameext"] = ext
+	listed := map[string]bool{}
+	secondary := []interface{}{}
+	if sf, ok := primary["secondaryFiles"].([]interface{}); ok {
+		for _, i := range sf {
+			if f, ok := i.(map[interface{}]interface{}); ok {
+				if l, ok := f["location"].(string); ok {
+					listed[strings.TrimPrefix(l, "file://")] = true
+				}
+			}
+			secondary = append(secondary, i)
+		}
+	}
+	for _, pattern := range patterns {
+		required := defaultRequired
+		if pattern.Required != "" {
+			r, err := js_eval.EvaluateExpression(pattern.Required, self)
+			if err != nil {
+				return err
+			}
+			required = r == true || r == "true"
+		}
+		names, err := secondaryNames(self, loc, pattern.Pattern, js_eval)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if listed[name] {
+				continue
+			}
+			info, err := os.Stat(name)
+			if err != nil {
+				if required {
+					return fmt.Errorf("Missing secondary file %s for %s", name, loc)
+				}
+				continue
+			}
+			listed[name] = true
+			class := "File"
+			if info.IsDir() {
+				class = "Directory"
+			}
+			secondary = append(secondary, map[interface{}]interface{}{"class": class, "location": name, "basename": filepath.Base(name)})
+		}
+	}
+	if len(secondary) > 0 {
+		primary["secondaryFiles"] = secondary
+	}
+	return nil
+}
+
+// secondaryNames returns the locations a pattern names for the primary
+// file at loc. Expressions get the primary File as self and may return
+// names relative to it, File or Directory objects, or a list of those.
+func secondaryNames(primary map[interface{}]interface{}, loc string, pattern string, js_eval cwl.JSEvaluator) ([]string, error) {
+	dir := filepath.Dir(loc)
+	if !strings.Contains(pattern, "$(") && !strings.Contains(pattern, "${") {
+		return []string{filepath.Join(dir, secondaryName(filepath.Base(loc), pattern))}, nil
+	}
+	v, err := js_eval.EvaluateExpression(pattern, primary)
+	if err != nil {
+		return nil, err
+	}
+	values := []interface{}{v}
+	if a, ok := v.([]interface{}); ok {
+		values = a
+	}
+	out := []string{}
+	for _, i := range values {
+		if s, ok := i.(string); ok {
+			if filepath.IsAbs(s) {
+				out = append(out, s)
+			} else {
+				out = append(out, filepath.Join(dir, s))
+			}
+		} else if f, ok := i.(map[interface{}]interface{}); ok {
+			if l, ok := f["location"].(string); ok {
+				out = append(out, strings.TrimPrefix(l, "file://"))
+			} else if p, ok := f["path"].(string); ok {
+				out = append(out, p)
+			} else if b, ok := f["basename"].(string); ok {
+				out = append(out, filepath.Join(dir, b))
+			}
+		} else if i != nil {
+			return nil, fmt.Errorf("Invalid secondaryFiles expression result: %#v", i)
+		}
+	}
+	return out, nil
+}
+
+// secondaryName applies a suffix pattern to a file name, every leading '^'
+// removes one extension before the rest of the pattern is appended
+func secondaryName(name string, pattern string) string {
+	for strings.HasPrefix(pattern, "^") {
+		pattern = pattern[1:]
+		if i := strings.LastIndex(name, "."); i > 0 {
+			name = name[:i]
+		}
+	}
+	return name + pattern
+}
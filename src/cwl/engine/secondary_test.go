@@ -0,0 +1,130 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the secondaryFiles of a step input come from the input parameter of the
+// tool it runs
+func TestStepSecondaryFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secondary_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"ref.fa", "ref.fa.fai"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := parseDoc(t, dir, map[string]string{
+		"wf.cwl": `cwlVersion: v1.0
+class: Workflow
+inputs:
+  ref: File
+outputs:
+  total:
+    type: int
+    outputSource: count/total
+steps:
+  count:
+    run: count.cwl
+    in: {ref: ref}
+    out: [total]
+`,
+		"count.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  InlineJavascriptRequirement: {}
+baseCommand: "true"
+inputs:
+  ref:
+    type: File
+    secondaryFiles: [.fai]
+outputs:
+  total:
+    type: int
+    outputBinding:
+      outputEval: $(inputs.ref.secondaryFiles.length)
+`,
+	}, "wf.cwl")
+
+	inputs := cwl.JSONDict{"ref": map[interface{}]interface{}{"class": "File", "location": filepath.Join(dir, "ref.fa")}}
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["total"] != 1 {
+		t.Errorf("Step got %v secondary files, expected 1", out["total"])
+	}
+}
+
+// Files in records, and in arrays of records, get the secondaryFiles of
+// their field
+func TestRecordSecondaryFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secondary_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"ref.fa", "ref.fa.fai"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+requirements:
+  InlineJavascriptRequirement: {}
+baseCommand: "true"
+inputs:
+  sample:
+    type:
+      type: record
+      fields:
+        ref:
+          type: File
+          secondaryFiles: [.fai]
+  samples:
+    type:
+      type: array
+      items:
+        type: record
+        fields:
+          ref:
+            type: File
+            secondaryFiles: [.fai]
+outputs:
+  single:
+    type: int
+    outputBinding:
+      outputEval: $(inputs.sample.ref.secondaryFiles.length)
+  listed:
+    type: int
+    outputBinding:
+      outputEval: $(inputs.samples[1].ref.secondaryFiles.length)
+`}, "tool.cwl")
+
+	ref := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{"class": "File", "location": filepath.Join(dir, "ref.fa")}
+	}
+	inputs := cwl.JSONDict{
+		"sample":  map[interface{}]interface{}{"ref": ref()},
+		"samples": []interface{}{map[interface{}]interface{}{"ref": ref()}, map[interface{}]interface{}{"ref": ref()}},
+	}
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["single"] != 1 {
+		t.Errorf("Record field got %v secondary files, expected 1", out["single"])
+	}
+	if out["listed"] != 1 {
+		t.Errorf("Field of an array of records got %v secondary files, expected 1", out["listed"])
+	}
+}
@@ -0,0 +1,177 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const STATE_FILE = "cwl.state.json"
+
+const (
+	STATUS_RUNNING = "running"
+	STATUS_SUCCESS = "success"
+	STATUS_FAILED  = "permanentFail"
+)
+
+// StepRecord is the state of a step. WorkDir is the host work directory
+// of its job, where its outputs are until the run finishes.
+type StepRecord struct {
+	Status  string
+	Outputs cwl.JSONDict
+	WorkDir string
+}
+
+// RunState is the persisted progress of a single run. It is written to
+// RunDir after every step so that an interrupted run can be picked up
+// again with LoadRunState.
+type RunState struct {
+	RunDir   string
+	Document string
+	Inputs   cwl.JSONDict
+	Steps    map[string]StepRecord
+	graph    cwl.JSONDict
+}
+
+func NewRunState(runDir string, document string, inputs cwl.JSONDict) (*RunState, error) {
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create run dir: %s", err)
+	}
+	out := &RunState{RunDir: runDir, Document: document, Inputs: inputs, Steps: map[string]StepRecord{}}
+	return out, nil
+}
+
+func LoadRunState(runDir string) (*RunState, error) {
+	data, err := ioutil.ReadFile(filepath.Join(runDir, STATE_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read run state: %s", err)
+	}
+	doc := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Unable to parse run state: %s", err)
+	}
+	out := &RunState{RunDir: runDir, Inputs: cwl.JSONDict{}, Steps: map[string]StepRecord{}}
+	if d, ok := doc["document"].(string); ok {
+		out.Document = d
+	}
+	if i, ok := doc["inputs"].(map[interface{}]interface{}); ok {
+		out.Inputs = cwl.JSONDict(i)
+	}
+	if s, ok := doc["steps"].(map[interface{}]interface{}); ok {
+		for k, v := range s {
+			rec := StepRecord{Outputs: cwl.JSONDict{}}
+			if base, ok := v.(map[interface{}]interface{}); ok {
+				if status, ok := base["status"].(string); ok {
+					rec.Status = status
+				}
+				if o, ok := base["outputs"].(map[interface{}]interface{}); ok {
+					rec.Outputs = cwl.JSONDict(o)
+				}
+				if w, ok := base["work_dir"].(string); ok {
+					rec.WorkDir = w
+				}
+			}
+			out.Steps[k.(string)] = rec
+		}
+	}
+	return out, nil
+}
+
+func (self *RunState) SetStatus(step string, status string) error {
+	rec := self.Steps[step]
+	rec.Status = status
+	self.Steps[step] = rec
+	return self.Save(nil)
+}
+
+func (self *RunState) StepDone(step string, outputs cwl.JSONDict, workDir string, graphState cwl.JSONDict) error {
+	self.Steps[step] = StepRecord{Status: STATUS_SUCCESS, Outputs: outputs, WorkDir: workDir}
+	return self.Save(graphState)
+}
+
+// Save writes the run state to the run directory. The graph state is only
+// stored for inspection, Restore rebuilds it from the step records. A nil
+// graph state keeps the last one saved.
+func (self *RunState) Save(graphState cwl.JSONDict) error {
+	if graphState != nil {
+		self.graph = graphState
+	}
+	steps := map[string]interface{}{}
+	for k, v := range self.Steps {
+		steps[k] = map[string]interface{}{
+			"status":   v.Status,
+			"outputs":  v.Outputs.Normalize(),
+			"work_dir": v.WorkDir,
+		}
+	}
+	doc := map[string]interface{}{
+		"document": self.Document,
+		"inputs":   self.Inputs.Normalize(),
+		"steps":    steps,
+	}
+	if self.graph != nil {
+		doc["graph"] = self.graph.Normalize()
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	//write to a temp file and rename, so a crash never leaves a partial state
+	path := filepath.Join(self.RunDir, STATE_FILE)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Restore builds a graph state for doc, replaying every step that completed
+// successfully and whose outputs are still present on disk. Steps that
+// didn't finish, or whose outputs are gone, are dropped so they run again.
+func (self *RunState) Restore(doc cwl.CWLDoc) cwl.JSONDict {
+	graphState := doc.NewGraphState(self.Inputs)
+	for step, rec := range self.Steps {
+		if rec.Status != STATUS_SUCCESS {
+			cwl.Infof("Step %s not complete (%s), will rerun", step, rec.Status)
+			delete(self.Steps, step)
+			continue
+		}
+		if missing := missingFiles(rec.Outputs); len(missing) > 0 {
+			cwl.Infof("Step %s outputs missing %s, will rerun", step, missing)
+			delete(self.Steps, step)
+			continue
+		}
+		cwl.Infof("Restoring step %s", step)
+		graphState = doc.UpdateStepResults(graphState, step, rec.Outputs)
+	}
+	return graphState
+}
+
+func missingFiles(x interface{}) []string {
+	out := []string{}
+	if base, ok := x.(cwl.JSONDict); ok {
+		x = map[interface{}]interface{}(base)
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if class, ok := base["class"]; ok && (class == "File" || class == "Directory") {
+			if loc, ok := base["location"].(string); ok {
+				loc = strings.TrimPrefix(loc, "file://")
+				if _, err := os.Stat(loc); err != nil {
+					out = append(out, loc)
+				}
+			}
+		}
+		for _, v := range base {
+			out = append(out, missingFiles(v)...)
+		}
+	} else if base, ok := x.([]interface{}); ok {
+		for _, v := range base {
+			out = append(out, missingFiles(v)...)
+		}
+	}
+	return out
+}
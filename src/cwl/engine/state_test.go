@@ -0,0 +1,98 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the work dirs of steps restored on resume are staged from and removed
+// like those of the steps that are run
+func TestResumeRestoredWorkDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+	doc := parseDoc(t, dir, map[string]string{
+		"wf.cwl": `cwlVersion: v1.0
+class: Workflow
+inputs: []
+outputs:
+  result:
+    type: File
+    outputSource: write/result
+steps:
+  write:
+    run: write.cwl
+    in: []
+    out: [result]
+  check:
+    run: check.cwl
+    in: {f: write/result}
+    out: []
+`,
+		"write.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: [echo, hi]
+inputs: []
+outputs:
+  result: stdout
+stdout: result.txt
+`,
+		"check.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: [test, -f, ` + marker + `]
+inputs:
+  f: File
+outputs: []
+`,
+	}, "wf.cwl")
+	runDir := filepath.Join(dir, "run")
+	outDir := filepath.Join(dir, "out")
+	os.Mkdir(outDir, 0755)
+	config := Config{TmpdirPrefix: dir, TmpOutdirPrefix: dir, Outdir: outDir, MaxCores: 1, MaxRam: 1024}
+
+	state, err := NewRunState(runDir, "wf.cwl", cwl.JSONDict{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec, err := NewExecutor(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec.RunState = state
+	if _, _, err := exec.Run(context.Background(), doc, cwl.JSONDict{}); err == nil {
+		t.Fatal("Check step did not fail")
+	}
+
+	ioutil.WriteFile(marker, []byte{}, 0644)
+	state, err = LoadRunState(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workDir := state.Steps["write"].WorkDir
+	if workDir == "" {
+		t.Fatal("Work dir of the write step not recorded")
+	}
+	exec, err = NewExecutor(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec.RunState = state
+	out, _, err := exec.Run(context.Background(), doc, cwl.JSONDict{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := out["result"].(map[interface{}]interface{})["location"].(string)
+	if filepath.Dir(result) != outDir {
+		t.Errorf("Output of the restored step at %s, not in %s", result, outDir)
+	}
+	if _, err := os.Stat(workDir); err == nil {
+		t.Errorf("Work dir %s of the restored step left behind", workDir)
+	}
+}
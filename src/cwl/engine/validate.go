@@ -0,0 +1,75 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ValidateOutputs checks an output object against the declared outputs of
+// a job: every output that is not optional must be present, values must
+// match their type, and the Files and Directories they name must exist.
+// Relative locations are taken to be in workdir. The error names the first
+// offending output.
+func ValidateOutputs(outputs cwl.JSONDict, schemas map[string]cwl.Schema, workdir string) error {
+	ids := []string{}
+	for k := range schemas {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids)
+	for _, k := range ids {
+		schema := schemas[k]
+		value := outputs[k]
+		if value == nil && !schema.IsOptional() && schema.TypeName != "null" {
+			return fmt.Errorf("Output %s is required but was not produced", k)
+		}
+		if err := schema.Validate(value); err != nil {
+			return fmt.Errorf("Output %s: %s", k, err)
+		}
+		if err := checkLocations(value, workdir); err != nil {
+			return fmt.Errorf("Output %s: %s", k, err)
+		}
+	}
+	return nil
+}
+
+func checkLocations(x interface{}, workdir string) error {
+	if base, ok := x.(cwl.JSONDict); ok {
+		x = map[interface{}]interface{}(base)
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if class, ok := base["class"]; ok && (class == "File" || class == "Directory") {
+			loc, ok := base["location"].(string)
+			if !ok {
+				loc, ok = base["path"].(string)
+			}
+			//literals with contents have no location yet
+			if ok {
+				loc = strings.TrimPrefix(loc, "file://")
+				if !filepath.IsAbs(loc) && workdir != "" {
+					loc = filepath.Join(workdir, loc)
+				}
+				if _, err := os.Stat(loc); err != nil {
+					return fmt.Errorf("%s %s does not exist", class, loc)
+				}
+			} else if _, ok := base["contents"]; !ok && class == "File" {
+				return fmt.Errorf("File has no location")
+			}
+		}
+		for _, v := range base {
+			if err := checkLocations(v, workdir); err != nil {
+				return err
+			}
+		}
+	} else if base, ok := x.([]interface{}); ok {
+		for _, v := range base {
+			if err := checkLocations(v, workdir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
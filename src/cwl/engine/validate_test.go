@@ -0,0 +1,72 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schemas := map[string]cwl.Schema{
+		"count": {TypeName: "int"},
+		"file":  {TypeName: "File"},
+		"maybe": {Types: []cwl.Schema{{TypeName: "null"}, {TypeName: "string"}}},
+	}
+	for _, c := range []struct {
+		outputs cwl.JSONDict
+		valid   bool
+	}{
+		{cwl.JSONDict{"count": 1, "file": map[interface{}]interface{}{"class": "File", "location": "a.txt"}}, true},
+		{cwl.JSONDict{"count": 1, "file": map[interface{}]interface{}{"class": "File", "location": "a.txt"}, "maybe": "x"}, true},
+		{cwl.JSONDict{"file": map[interface{}]interface{}{"class": "File", "location": "a.txt"}}, false},
+		{cwl.JSONDict{"count": "one", "file": map[interface{}]interface{}{"class": "File", "location": "a.txt"}}, false},
+		{cwl.JSONDict{"count": 1, "file": map[interface{}]interface{}{"class": "File", "location": "b.txt"}}, false},
+		{cwl.JSONDict{"count": 1, "file": map[interface{}]interface{}{"class": "File", "location": "a.txt"}, "maybe": 2}, false},
+	} {
+		err := ValidateOutputs(c.outputs, schemas, dir)
+		if c.valid && err != nil {
+			t.Errorf("Outputs %v not valid: %s", c.outputs, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("Outputs %v valid", c.outputs)
+		}
+	}
+}
+
+// a cwl.output.json holding no outputs replaces the output bindings all
+// the same
+func TestEmptyOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := parseDoc(t, dir, map[string]string{"tool.cwl": `cwlVersion: v1.0
+class: CommandLineTool
+baseCommand: [sh, -c, "echo a > a.txt; echo {} > cwl.output.json"]
+inputs: []
+outputs:
+  out:
+    type: File?
+    outputBinding: {glob: a.txt}
+`}, "tool.cwl")
+
+	out, _, err := testExecutor(t, dir, 1).Run(context.Background(), doc, cwl.JSONDict{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["out"] != nil {
+		t.Errorf("Output globbed despite cwl.output.json: %v", out["out"])
+	}
+}
@@ -0,0 +1,210 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// workDirStager places the entries of an InitialWorkDirRequirement in the
+// host side of a job's work directory. staged maps the location of every
+// File or Directory it placed to the path the job sees it at, names holds
+// the entry names used so far.
+type workDirStager struct {
+	hostDir string
+	jobDir  string
+	staged  map[string]string
+	names   map[string]bool
+}
+
+// stageInitialWorkDir evaluates the listing of req and writes it to
+// hostDir, the host path of the job's work directory jobDir
+func stageInitialWorkDir(req cwl.InitialWorkDirRequirement, js_eval cwl.JSEvaluator, hostDir string, jobDir string) (map[string]string, error) {
+	stager := workDirStager{hostDir: hostDir, jobDir: jobDir, staged: map[string]string{}, names: map[string]bool{}}
+	if req.Expression != "" {
+		v, err := js_eval.EvaluateExpression(req.Expression, nil)
+		if err != nil {
+			return nil, fmt.Errorf("InitialWorkDir listing error: %s", err)
+		}
+		if err := stager.stageValue(v, "", false); err != nil {
+			return nil, err
+		}
+		return stager.staged, nil
+	}
+	for _, d := range req.Listing {
+		if d.Value != nil {
+			if err := stager.stageValue(d.Value, "", d.Writable); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entry, err := js_eval.EvaluateExpression(d.Entry, nil)
+		if err != nil {
+			return nil, fmt.Errorf("InitialWorkDir entry error: %s", err)
+		}
+		if d.Entryname == "" {
+			if err := stager.stageValue(entry, "", d.Writable); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		name, err := js_eval.EvaluateExpressionString(d.Entryname, nil)
+		if err != nil {
+			return nil, fmt.Errorf("InitialWorkDir entryname error: %s", err)
+		}
+		if err := stager.stageEntry(name, entry, d.Writable); err != nil {
+			return nil, err
+		}
+	}
+	return stager.staged, nil
+}
+
+// stageValue stages what a listing expression returned: Files,
+// Directories, Dirents or a list of them. null entries are skipped.
+func (self *workDirStager) stageValue(v interface{}, name string, writable bool) error {
+	if base, ok := v.(cwl.JSONDict); ok {
+		v = map[interface{}]interface{}(base)
+	}
+	if v == nil {
+		return nil
+	}
+	if base, ok := v.([]interface{}); ok {
+		for _, i := range base {
+			if err := self.stageValue(i, "", writable); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	base, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("Invalid InitialWorkDir listing entry: %#v", v)
+	}
+	if entry, ok := base["entry"]; ok {
+		n, _ := base["entryname"].(string)
+		w, _ := base["writable"].(bool)
+		return self.stageEntry(n, entry, w)
+	}
+	if class := base["class"]; class != "File" && class != "Directory" {
+		return fmt.Errorf("Invalid InitialWorkDir listing entry: %#v", v)
+	}
+	if name == "" {
+		name, _ = base["basename"].(string)
+	}
+	loc, _ := base["location"].(string)
+	if name == "" && loc != "" {
+		name = filepath.Base(cwl.ResolveLocation(loc, ""))
+	}
+	if err := self.checkName(name); err != nil {
+		return err
+	}
+	dst := filepath.Join(self.hostDir, name)
+	if loc == "" {
+		//File and Directory literals
+		o, err := writeLiteral(base, filepath.Dir(dst))
+		if err != nil {
+			return err
+		}
+		if o["location"] != dst {
+			return os.Rename(o["location"].(string), dst)
+		}
+		return nil
+	}
+	loc = cwl.ResolveLocation(loc, "")
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	var err error
+	if writable {
+		err = copyPath(loc, dst)
+	} else {
+		err = readOnlyPath(loc, dst)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to stage %s: %s", loc, err)
+	}
+	self.staged[loc] = filepath.Join(self.jobDir, name)
+	return nil
+}
+
+// stageEntry stages a Dirent: File and Directory entries are placed under
+// name, anything else is written to a file called name, strings as they
+// are and other values as JSON
+func (self *workDirStager) stageEntry(name string, entry interface{}, writable bool) error {
+	if base, ok := entry.(cwl.JSONDict); ok {
+		entry = map[interface{}]interface{}(base)
+	}
+	if base, ok := entry.(map[interface{}]interface{}); ok {
+		if class := base["class"]; class == "File" || class == "Directory" {
+			return self.stageValue(base, name, writable)
+		}
+	}
+	if base, ok := entry.([]interface{}); ok && name == "" {
+		return self.stageValue(base, "", writable)
+	}
+	if err := self.checkName(name); err != nil {
+		return err
+	}
+	dst := filepath.Join(self.hostDir, name)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, []byte(cwl.JSValueString(entry)), 0644)
+}
+
+// checkName rejects entry names that would land outside the work directory
+// or replace an earlier entry
+func (self *workDirStager) checkName(name string) error {
+	if name == "" {
+		return fmt.Errorf("InitialWorkDir entry has no name")
+	}
+	clean := filepath.Clean(name)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("InitialWorkDir entry %s is outside the working directory", name)
+	}
+	if self.names[clean] {
+		return fmt.Errorf("InitialWorkDir entry %s is listed more than once", name)
+	}
+	self.names[clean] = true
+	return nil
+}
+
+// readOnlyPath makes a read-only entry, a copy of src with the write
+// permissions removed from its files. Linking would let the job write
+// through to the caller's input, inside a container as well, as the work
+// directory is mounted writable.
+func readOnlyPath(src, dst string) error {
+	if err := copyPath(src, dst); err != nil {
+		return err
+	}
+	return filepath.Walk(dst, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return os.Chmod(p, info.Mode()&^0222)
+	})
+}
+
+// workDirMapper gives inputs that were staged into the work directory the
+// path they were staged at
+type workDirMapper struct {
+	mapper PathMapper
+	staged map[string]string
+}
+
+func (self workDirMapper) MapFile(in map[interface{}]interface{}) map[interface{}]interface{} {
+	out := self.mapper.MapFile(in)
+	if loc, ok := out["location"].(string); ok {
+		if p, ok := self.staged[loc]; ok {
+			out["path"] = p
+			out["dirname"] = filepath.Dir(p)
+		}
+	}
+	return out
+}
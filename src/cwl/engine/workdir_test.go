@@ -0,0 +1,77 @@
+package cwl_engine
+
+import (
+	"cwl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a tool writing to a read-only entry must not change the caller's file
+func TestReadOnlyEntryIsolated(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "workdir_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "input.txt")
+	if err := ioutil.WriteFile(src, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srcDir := filepath.Join(tmp, "indir")
+	os.Mkdir(srcDir, 0755)
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "inner.txt"), []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	workdir := filepath.Join(tmp, "work")
+	os.Mkdir(workdir, 0755)
+
+	req := cwl.InitialWorkDirRequirement{Listing: []cwl.Dirent{
+		{Value: map[interface{}]interface{}{"class": "File", "location": src}},
+		{Value: map[interface{}]interface{}{"class": "Directory", "location": srcDir}},
+	}}
+	if _, err := stageInitialWorkDir(req, cwl.JSEvaluator{}, workdir, "/job"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Join(workdir, "input.txt"), filepath.Join(workdir, "indir", "inner.txt")} {
+		//a write may be refused, root is allowed to make it
+		if f, err := os.OpenFile(p, os.O_WRONLY|os.O_TRUNC, 0); err == nil {
+			f.Write([]byte("changed"))
+			f.Close()
+		}
+	}
+	for _, p := range []string{src, filepath.Join(srcDir, "inner.txt")} {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "original" {
+			t.Errorf("%s was changed through the work directory: %q", p, data)
+		}
+	}
+}
+
+func TestDuplicateEntryname(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "workdir_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, second := range []cwl.Dirent{
+		{Entryname: "./a.txt", Entry: "two"},
+		{Value: map[interface{}]interface{}{"class": "File", "basename": "a.txt", "contents": "two"}},
+	} {
+		workdir, err := ioutil.TempDir(tmp, "work")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := cwl.InitialWorkDirRequirement{Listing: []cwl.Dirent{{Entryname: "a.txt", Entry: "one"}, second}}
+		if _, err := stageInitialWorkDir(req, cwl.JSEvaluator{}, workdir, "/job"); err == nil {
+			t.Errorf("Entry %#v replaced an earlier one", second)
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(workdir, "a.txt")); string(data) != "one" {
+			t.Errorf("Entry a.txt holds %q", data)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package cwl
 
 import (
 	"fmt"
-	"log"
 )
 
 func (self ExpressionTool) NewGraphState(inputs JSONDict) JSONDict {
@@ -80,7 +79,7 @@ func (self *ExpressionInput) Evaluate(inputs JSONDict) (JobArgument, error) {
 	if base, ok := inputs[self.Id]; ok {
 		a, err := self.SchemaEvaluate(base)
 		if err != nil {
-			log.Printf("Schema Evaluation Error: %s", err)
+			Debugf("Schema Evaluation Error: %s", err)
 			return JobArgument{}, err
 		}
 		out_arg = a
@@ -88,11 +87,11 @@ func (self *ExpressionInput) Evaluate(inputs JSONDict) (JobArgument, error) {
 		if self.Default != nil {
 			a, err := self.SchemaEvaluate(*self.Default)
 			if err != nil {
-				log.Printf("Schema Evaluation Error: %s", err)
+				Debugf("Schema Evaluation Error: %s", err)
 				return JobArgument{}, err
 			}
 			out_arg = a
-			log.Printf("Default Eval: %s %#v", self.Id, out_arg)
+			Debugf("Default Eval: %s %#v", self.Id, out_arg)
 		} else if self.IsOptional() {
 			return JobArgument{}, nil
 		} else {
@@ -1,7 +1,7 @@
 package cwl
 
 import (
-	"log"
+	"regexp"
 	"strings"
 )
 
@@ -25,53 +25,174 @@ func (self *JobArgument) GetFiles() []JobFile {
 }
 
 func (self *JobArgument) GetArgs(evaluator JSEvaluator, pathMapper func(interface{}) interface{}) ([]string, error) {
-	cmd, bound, err := self.EvaluateStrings(evaluator, pathMapper)
-	if err != nil || !bound {
-		return []string{}, err
-	}
-	return cmd, nil
+	return self.EvaluateStrings(evaluator, pathMapper, false)
+}
+
+// GetShellArgs returns the words of the argument quoted for /bin/sh,
+// except for bindings with shellQuote: false
+func (self *JobArgument) GetShellArgs(evaluator JSEvaluator, pathMapper func(interface{}) interface{}) ([]string, error) {
+	return self.EvaluateStrings(evaluator, pathMapper, true)
 }
 
-func (self *JobArgument) EvaluateStrings(evaluator JSEvaluator, pathMapper func(interface{}) interface{}) ([]string, bool, error) {
+// EvaluateStrings renders the argument's binding, followed by the bindings
+// of its children, as command line words
+func (self *JobArgument) EvaluateStrings(evaluator JSEvaluator, pathMapper func(interface{}) interface{}, quote bool) ([]string, error) {
 	out := []string{}
 
-	if self.Value != "" {
-		var e string
-		var err error
-		if self.File != nil {
-			f := pathMapper(self.File.ToJSONDict()).(JSONDict)
-			log.Printf("self = %s", f)
-			e, err = evaluator.EvaluateExpressionString(self.Value, &f)
+	if self.Bound {
+		var words []string
+		if self.Value != "" {
+			e, err := evaluator.EvaluateExpressionString(self.Value, nil)
+			if err != nil {
+				return []string{}, err
+			}
+			words = self.prefixed(e)
 		} else {
-			e, err = evaluator.EvaluateExpressionString(self.Value, nil)
+			value := self.value(pathMapper)
+			if self.ValueFrom != "" {
+				v, err := evaluator.EvaluateExpression(self.ValueFrom, value)
+				if err != nil {
+					return []string{}, err
+				}
+				value = v
+			}
+			words = self.valueWords(value)
 		}
-		if err != nil {
-			return []string{}, false, err
+		if quote && !self.NoShellQuote {
+			for i := range words {
+				words[i] = ShellQuote(words[i])
+			}
 		}
-		out = append(out, e)
-	} else if self.Bound && self.File != nil {
-		out = append(out, self.File.Location)
+		out = append(out, words...)
 	}
-	for _, x := range self.Children {
-		e, _, err := x.EvaluateStrings(evaluator, pathMapper)
-		if err != nil {
-			return []string{}, false, err
+	//a valueFrom replaces the value the children were bound to
+	if self.ValueFrom == "" {
+		for _, x := range self.Children {
+			e, err := x.EvaluateStrings(evaluator, pathMapper, quote)
+			if err != nil {
+				return []string{}, err
+			}
+			out = append(out, e...)
 		}
-		out = append(out, e...)
 	}
+	return out, nil
+}
 
-	if self.Join != "" {
-		out = []string{strings.Join(out, self.Join)}
+// value returns the bound value with File and Directory paths mapped for
+// the job, rebuilt from the children of arrays and records
+func (self *JobArgument) value(pathMapper func(interface{}) interface{}) interface{} {
+	if self.File != nil {
+		return pathMapper(self.File.ToJSONDict())
+	}
+	if base, ok := self.RawValue.([]interface{}); ok && len(base) == len(self.Children) {
+		out := []interface{}{}
+		for i := range self.Children {
+			out = append(out, self.Children[i].value(pathMapper))
+		}
+		return out
 	}
+	if base, ok := self.RawValue.(map[interface{}]interface{}); ok {
+		out := map[interface{}]interface{}{}
+		for k, v := range base {
+			out[k] = pathMapper(v)
+		}
+		for i := range self.Children {
+			out[self.Children[i].Id] = self.Children[i].value(pathMapper)
+		}
+		return out
+	}
+	return pathMapper(self.RawValue)
+}
 
-	if self.Prefix != "" {
-		out = append([]string{self.Prefix}, out...)
+// valueWords renders a value according to the binding: nothing for null
+// and false, the prefix alone for true, records and unjoined arrays, and
+// the prefix with the value otherwise
+func (self *JobArgument) valueWords(value interface{}) []string {
+	if base, ok := value.(JSONDict); ok {
+		value = map[interface{}]interface{}(base)
 	}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bool:
+		if v && self.Prefix != "" {
+			return []string{self.Prefix}
+		}
+		return nil
+	case []interface{}:
+		if self.Join != "" && len(v) > 0 {
+			items := []string{}
+			for _, i := range v {
+				items = append(items, argString(i))
+			}
+			return self.prefixed(strings.Join(items, self.Join))
+		}
+		if self.ValueFrom != "" {
+			out := []string{}
+			if self.Prefix != "" {
+				out = append(out, self.Prefix)
+			}
+			for _, i := range v {
+				out = append(out, argString(i))
+			}
+			return out
+		}
+		if self.Prefix != "" && len(v) > 0 {
+			return []string{self.Prefix}
+		}
+		return nil
+	case map[interface{}]interface{}:
+		if v["class"] == "File" || v["class"] == "Directory" {
+			return self.prefixed(argString(v))
+		}
+		if self.Prefix != "" {
+			return []string{self.Prefix}
+		}
+		return nil
+	}
+	return self.prefixed(argString(value))
+}
 
-	return out, self.Bound, nil
+func (self *JobArgument) prefixed(s string) []string {
+	if self.Prefix == "" {
+		return []string{s}
+	}
+	if self.NoSeparate {
+		return []string{self.Prefix + s}
+	}
+	return []string{self.Prefix, s}
+}
+
+// argString renders a single value on the command line, Files and
+// Directories by their path
+func argString(value interface{}) string {
+	if base, ok := value.(JSONDict); ok {
+		value = map[interface{}]interface{}(base)
+	}
+	if base, ok := value.(map[interface{}]interface{}); ok {
+		if base["class"] == "File" || base["class"] == "Directory" {
+			if p, ok := base["path"].(string); ok {
+				return p
+			}
+			if l, ok := base["location"].(string); ok {
+				return l
+			}
+		}
+	}
+	return JSValueString(value)
 }
 
 func (self *JobArgument) EvaluateObject(evaluator JSEvaluator) (interface{}, error) {
-	log.Printf("Expression:%s", self.RawValue)
+	Debugf("Expression:%s", self.RawValue)
 	return self.RawValue, nil
 }
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// ShellQuote quotes a string so /bin/sh reads it as a single word
+func ShellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
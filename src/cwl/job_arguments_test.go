@@ -0,0 +1,40 @@
+package cwl
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, c := range []struct {
+		word   string
+		quoted string
+	}{
+		{"plain", "plain"},
+		{"/path/to/file.txt", "/path/to/file.txt"},
+		{"--opt=a,b", "--opt=a,b"},
+		{"two words", "'two words'"},
+		{"", "''"},
+		{"it's", `'it'"'"'s'`},
+		{"$HOME", "'$HOME'"},
+		{"a|b;c&d", "'a|b;c&d'"},
+		{"*.txt", "'*.txt'"},
+	} {
+		if q := ShellQuote(c.word); q != c.quoted {
+			t.Errorf("ShellQuote(%q) = %s, expected %s", c.word, q, c.quoted)
+		}
+	}
+}
+
+// the shell reads a quoted word back as it was
+func TestShellQuoteRoundTrip(t *testing.T) {
+	for _, word := range []string{"two words", "it's", "$HOME `id`", "a\nb", `back\slash "quoted"`} {
+		out, err := exec.Command("/bin/sh", "-c", "printf %s "+ShellQuote(word)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != word {
+			t.Errorf("Shell read %q back as %q", word, out)
+		}
+	}
+}
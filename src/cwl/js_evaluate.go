@@ -1,72 +1,190 @@
 package cwl
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/robertkrimen/otto"
-	"log"
-	"regexp"
+	"math"
+	"reflect"
+	"strings"
 )
 
-var EXP_RE_STRING, _ = regexp.Compile(`(.*)\$\((.*)\)(.*)`)
-var EXP_RE, _ = regexp.Compile(`\$\((.*)\)`)
-
-func (self *JSEvaluator) EvaluateExpressionString(expression string, js_self *JSONDict) (string, error) {
-
-	matches := EXP_RE_STRING.FindStringSubmatch(expression)
-	if matches == nil {
-		return expression, nil
+// findExpression returns the start and end of the first $(...) or ${...}
+// in s at or after offset, or -1 if there is none. Brackets inside quoted
+// strings are skipped, and a backslash before the $ escapes it.
+func findExpression(s string, offset int) (int, int) {
+	for i := offset; i < len(s)-1; i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] != '$' || (s[i+1] != '(' && s[i+1] != '{') {
+			continue
+		}
+		depth := 0
+		var quote byte
+		for j := i + 1; j < len(s); j++ {
+			c := s[j]
+			if quote != 0 {
+				if c == '\\' {
+					j++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			switch c {
+			case '"', '\'':
+				quote = c
+			case '(', '{', '[':
+				depth++
+			case ')', '}', ']':
+				depth--
+				if depth == 0 {
+					return i, j + 1
+				}
+			}
+		}
+		return -1, -1
 	}
-	log.Printf("JS Expression: %s", matches[2])
-	//log.Printf("JS Inputs: %#v", self.Inputs.Normalize())
+	return -1, -1
+}
+
+func (self *JSEvaluator) newVM(js_self interface{}) *otto.Otto {
 	vm := otto.New()
-	vm.Set("runtime", map[string]interface{}{"cores": 4})
+	runtime := map[string]interface{}{}
+	if self.Runtime != nil {
+		runtime = self.Runtime.Normalize()
+	}
+	vm.Set("runtime", runtime)
 	vm.Set("inputs", self.Inputs.Normalize())
-	if js_self != nil {
-		vm.Set("self", js_self.Normalize())
+	vm.Set("self", mapNormalize(js_self))
+	return vm
+}
+
+func (self *JSEvaluator) evaluate(vm *otto.Otto, expression string) (interface{}, error) {
+	var code string
+	if strings.HasPrefix(expression, "${") {
+		code = "(function(){" + expression[2:len(expression)-1] + "})()"
+	} else {
+		code = "(" + expression[2:len(expression)-1] + ")"
+	}
+	Debugf("JS Expression: %s", code)
+	out, err := vm.Run(code)
+	if err != nil {
+		return nil, fmt.Errorf("Expression %s failed: %s", expression, err)
+	}
+	value, err := out.Export()
+	if err != nil {
+		return nil, err
 	}
-	out, err := vm.Run(matches[2])
-	log.Printf("JS:%s = %s\n", matches[2], out)
-	return fmt.Sprintf("%s%s%s", matches[1], out, matches[3]), err
+	value = fromJS(value)
+	Debugf("JS:%s = %#v", code, value)
+	return value, nil
 }
 
-func otto2map(obj *otto.Object) JSONDict {
-	out := JSONDict{}
-	for _, i := range obj.Keys() {
-		val, _ := obj.Get(i)
-		if val.IsBoolean() {
-			out[i], _ = val.ToBoolean()
-		}
-		if val.IsBoolean() {
-			out[i], _ = val.ToBoolean()
-		}
-		if val.IsString() {
-			out[i], _ = val.ToString()
-		}
-		if val.IsNumber() {
-			out[i], _ = val.Export()
-		}
-		if val.IsObject() {
-			out[i] = otto2map(val.Object())
+// EvaluateExpression evaluates a CWL parameter reference or expression. A
+// string that is exactly one $(...) or ${...} returns the value it
+// evaluates to, otherwise every expression found is interpolated into the
+// string. js_self is bound to `self`.
+func (self *JSEvaluator) EvaluateExpression(expression string, js_self interface{}) (interface{}, error) {
+	start, end := findExpression(expression, 0)
+	if start == -1 {
+		return strings.Replace(expression, "\\$", "$", -1), nil
+	}
+	vm := self.newVM(js_self)
+	if start == 0 && end == len(expression) {
+		return self.evaluate(vm, expression)
+	}
+	out := ""
+	last := 0
+	for start != -1 {
+		value, err := self.evaluate(vm, expression[start:end])
+		if err != nil {
+			return nil, err
 		}
+		out += strings.Replace(expression[last:start], "\\$", "$", -1) + JSValueString(value)
+		last = end
+		start, end = findExpression(expression, end)
 	}
-	return out
+	out += strings.Replace(expression[last:], "\\$", "$", -1)
+	return out, nil
 }
 
-func (self *JSEvaluator) EvaluateExpressionObject(expression string, js_self *JSONDict) (JSONDict, error) {
+func (self *JSEvaluator) EvaluateExpressionString(expression string, js_self *JSONDict) (string, error) {
+	var s interface{}
+	if js_self != nil {
+		s = *js_self
+	}
+	out, err := self.EvaluateExpression(expression, s)
+	if err != nil {
+		return "", err
+	}
+	return JSValueString(out), nil
+}
 
-	matches := EXP_RE.FindStringSubmatch(expression)
-	if matches == nil {
+func (self *JSEvaluator) EvaluateExpressionObject(expression string, js_self *JSONDict) (JSONDict, error) {
+	var s interface{}
+	if js_self != nil {
+		s = *js_self
+	}
+	out, err := self.EvaluateExpression(expression, s)
+	if err != nil {
+		return JSONDict{}, err
+	}
+	if base, ok := out.(map[interface{}]interface{}); ok {
+		return JSONDict(base), nil
+	} else if out == nil {
 		return JSONDict{}, nil
 	}
-	log.Printf("JS Expression: %s", matches[1])
-	vm := otto.New()
-	vm.Set("runtime", map[string]interface{}{"cores": 4})
-	ninputs := self.Inputs.Normalize()
-	//log.Printf("Expression Inputs: %#v", ninputs)
-	vm.Set("inputs", ninputs)
-	if js_self != nil {
-		vm.Set("self", js_self.Normalize())
+	return JSONDict{}, fmt.Errorf("Expression did not return an object: %#v", out)
+}
+
+// JSValueString renders an evaluated value the way it is interpolated
+// into a string: strings as is, everything else as JSON
+func JSValueString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	o, err := json.Marshal(mapNormalize(value))
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(o)
+}
+
+// fromJS converts an exported otto value to the types the yaml parser
+// produces, so expression results can be mixed with parsed documents.
+// Javascript has a single number type, integral numbers become ints so
+// they are valid for int and long parameters, float and double accept
+// them too.
+func fromJS(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch v := value.(type) {
+	case int64:
+		return int(v)
+	case float64:
+		//larger floats are not exact integers
+		if v == math.Trunc(v) && math.Abs(v) <= 1<<53 {
+			return int(v)
+		}
+		return v
+	case map[string]interface{}:
+		out := map[interface{}]interface{}{}
+		for k, i := range v {
+			out[k] = fromJS(i)
+		}
+		return out
+	}
+	r := reflect.ValueOf(value)
+	if r.Kind() == reflect.Slice {
+		out := make([]interface{}, r.Len())
+		for i := 0; i < r.Len(); i++ {
+			out[i] = fromJS(r.Index(i).Interface())
+		}
+		return out
 	}
-	out, err := vm.Run("out=" + matches[1])
-	return otto2map(out.Object()), err
+	return value
 }
@@ -0,0 +1,33 @@
+package cwl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvaluateExpressionNumbers(t *testing.T) {
+	js_eval := JSEvaluator{Inputs: JSONDict{"n": 7}}
+	for _, c := range []struct {
+		expression string
+		value      interface{}
+	}{
+		{"$(inputs.n)", 7},
+		{"$(inputs.n / 2)", 3.5},
+		{"$(3.0)", 3},
+		{"$(inputs.n * 0.5)", 3.5},
+		{"$(Math.pow(2, 40))", 1 << 40},
+		{"$(Math.pow(2, 60))", float64(1 << 60)},
+		{"$([1.5, 2.0])", []interface{}{1.5, 2}},
+		{"n=$(inputs.n / 2)", "n=3.5"},
+		{"n=$(6 / 2)", "n=3"},
+	} {
+		value, err := js_eval.EvaluateExpression(c.expression, nil)
+		if err != nil {
+			t.Errorf("%s: %s", c.expression, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, c.value) {
+			t.Errorf("%s = %#v, expected %#v", c.expression, value, c.value)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package cwl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	DEBUG LogLevel = iota
+	INFO
+	WARNING
+	ERROR
+)
+
+var LOG_LEVEL_NAMES = map[LogLevel]string{
+	DEBUG:   "debug",
+	INFO:    "info",
+	WARNING: "warning",
+	ERROR:   "error",
+}
+
+func (self LogLevel) String() string {
+	return LOG_LEVEL_NAMES[self]
+}
+
+func ParseLogLevel(name string) (LogLevel, error) {
+	for k, v := range LOG_LEVEL_NAMES {
+		if v == strings.ToLower(name) {
+			return k, nil
+		}
+	}
+	return INFO, fmt.Errorf("Unknown log level: %s", name)
+}
+
+// Log field names used across the engine
+const (
+	LOG_RUN_ID  = "run_id"
+	LOG_STEP_ID = "step_id"
+	LOG_JOB_ID  = "job_id"
+	LOG_EVENT   = "event"
+)
+
+type logSink struct {
+	lock       sync.Mutex
+	human      io.Writer
+	humanLevel LogLevel
+	jsonOut    io.Writer
+	jsonLevel  LogLevel
+}
+
+// Logger writes human readable lines to one writer and JSON lines to
+// another, each with its own level. Loggers made by With share the writers
+// of their parent and add fields to every record.
+type Logger struct {
+	sink   *logSink
+	fields map[string]interface{}
+}
+
+func NewLogger(human io.Writer, level LogLevel) *Logger {
+	return &Logger{sink: &logSink{human: human, humanLevel: level}, fields: map[string]interface{}{}}
+}
+
+// SetJSONOutput adds a JSON lines output, receiving records at level and
+// above
+func (self *Logger) SetJSONOutput(out io.Writer, level LogLevel) {
+	self.sink.lock.Lock()
+	defer self.sink.lock.Unlock()
+	self.sink.jsonOut = out
+	self.sink.jsonLevel = level
+}
+
+func (self *Logger) SetLevel(level LogLevel) {
+	self.sink.lock.Lock()
+	defer self.sink.lock.Unlock()
+	self.sink.humanLevel = level
+}
+
+func (self *Logger) With(key string, value interface{}) *Logger {
+	fields := map[string]interface{}{}
+	for k, v := range self.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &Logger{sink: self.sink, fields: fields}
+}
+
+func (self *Logger) Log(level LogLevel, msg string, fields map[string]interface{}) {
+	self.sink.lock.Lock()
+	defer self.sink.lock.Unlock()
+	now := time.Now()
+	if self.sink.human != nil && level >= self.sink.humanLevel {
+		prefix := ""
+		if s, ok := self.fields[LOG_STEP_ID]; ok {
+			prefix = fmt.Sprintf("[%s] ", s)
+		}
+		fmt.Fprintf(self.sink.human, "%s %-7s %s%s\n", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), prefix, msg)
+	}
+	if self.sink.jsonOut != nil && level >= self.sink.jsonLevel {
+		record := map[string]interface{}{}
+		for k, v := range self.fields {
+			record[k] = v
+		}
+		for k, v := range fields {
+			record[k] = v
+		}
+		record["time"] = now.Format(time.RFC3339Nano)
+		record["level"] = level.String()
+		record["msg"] = msg
+		data, err := json.Marshal(record)
+		if err != nil {
+			data, _ = json.Marshal(map[string]interface{}{"time": record["time"], "level": record["level"], "msg": msg})
+		}
+		self.sink.jsonOut.Write(append(data, '\n'))
+	}
+}
+
+func (self *Logger) Debugf(format string, args ...interface{}) {
+	self.Log(DEBUG, fmt.Sprintf(format, args...), nil)
+}
+
+func (self *Logger) Infof(format string, args ...interface{}) {
+	self.Log(INFO, fmt.Sprintf(format, args...), nil)
+}
+
+func (self *Logger) Warnf(format string, args ...interface{}) {
+	self.Log(WARNING, fmt.Sprintf(format, args...), nil)
+}
+
+func (self *Logger) Errorf(format string, args ...interface{}) {
+	self.Log(ERROR, fmt.Sprintf(format, args...), nil)
+}
+
+// Logging is the package wide logger, used by the parser and engine when
+// no more specific logger is at hand
+var Logging = NewLogger(os.Stderr, INFO)
+
+func Debugf(format string, args ...interface{}) {
+	Logging.Debugf(format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	Logging.Infof(format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	Logging.Warnf(format, args...)
+}
+
+// LogErrorf logs at the ERROR level, it doesn't return an error
+func LogErrorf(format string, args ...interface{}) {
+	Logging.Errorf(format, args...)
+}
@@ -0,0 +1,57 @@
+package cwl
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevelsAndFields(t *testing.T) {
+	human := &bytes.Buffer{}
+	jsonOut := &bytes.Buffer{}
+	logger := NewLogger(human, WARNING)
+	logger.SetJSONOutput(jsonOut, DEBUG)
+	step := logger.With(LOG_STEP_ID, "count").With(LOG_JOB_ID, "job_1")
+	step.Debugf("debug %d", 1)
+	step.Warnf("warning %d", 2)
+	logger.Errorf("error %d", 3)
+
+	lines := strings.Split(strings.TrimSpace(human.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Human output %q, expected the warning and the error", human.String())
+	}
+	if !strings.Contains(lines[0], "WARNING [count] warning 2") {
+		t.Errorf("Warning line %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "ERROR   error 3") {
+		t.Errorf("Error line %q", lines[1])
+	}
+
+	records := []map[string]interface{}{}
+	for _, l := range strings.Split(strings.TrimSpace(jsonOut.String()), "\n") {
+		r := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(l), &r); err != nil {
+			t.Fatalf("Invalid JSON line %q: %s", l, err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d JSON records, expected 3", len(records))
+	}
+	if r := records[0]; r["msg"] != "debug 1" || r["level"] != "debug" || r[LOG_STEP_ID] != "count" || r[LOG_JOB_ID] != "job_1" {
+		t.Errorf("Debug record %v", r)
+	}
+	if _, ok := records[2][LOG_STEP_ID]; ok {
+		t.Errorf("Fields of a derived logger leaked to its parent: %v", records[2])
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	if l, err := ParseLogLevel("Warning"); err != nil || l != WARNING {
+		t.Errorf("Level %s %v, expected warning", l, err)
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("Unknown level accepted")
+	}
+}
@@ -6,9 +6,9 @@ import (
 	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
-	"log"
-	"path"
+	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -23,37 +23,44 @@ func InputParse(path string) (JSONDict, error) {
 	base_path := filepath.Dir(x)
 
 	out := AdjustInputs(doc, base_path).(map[interface{}]interface{})
-	log.Printf("Inputs: %#v", out)
+	Debugf("Inputs: %#v", out)
 	return out, err
 }
 
+// ResolveLocation turns a File or Directory location into a local path.
+// file:// URIs are decoded and relative locations are taken to be in
+// basePath.
+func ResolveLocation(location string, basePath string) string {
+	if strings.HasPrefix(location, "file://") {
+		if u, err := url.Parse(location); err == nil {
+			location = u.Path
+		} else {
+			location = strings.TrimPrefix(location, "file://")
+		}
+	}
+	if !filepath.IsAbs(location) && basePath != "" {
+		location = filepath.Join(basePath, location)
+	}
+	return location
+}
+
 func AdjustInputs(input interface{}, basePath string) interface{} {
 	if base, ok := input.(map[interface{}]interface{}); ok {
 		out := map[interface{}]interface{}{}
 		if class, ok := base["class"]; ok {
-			log.Printf("class: %s", class)
-			if class == "File" {
-				for k, v := range base {
-					if k == "path" {
-						out["path"] = filepath.Join(basePath, v.(string))
-					} else if k == "location" {
-						out["location"] = filepath.Join(basePath, v.(string))
-					} else {
-						out[k] = v
-					}
-				}
-			} else if class == "Directory" {
+			Debugf("class: %s", class)
+			if class == "File" || class == "Directory" {
 				for k, v := range base {
-					if k == "path" {
-						out["path"] = filepath.Join(basePath, v.(string))
-					} else if k == "location" {
-						out["location"] = filepath.Join(basePath, v.(string))
+					if k == "path" || k == "location" {
+						out[k] = ResolveLocation(v.(string), basePath)
+					} else if k == "secondaryFiles" || k == "listing" {
+						out[k] = AdjustInputs(v, basePath)
 					} else {
 						out[k] = v
 					}
 				}
 			} else {
-				log.Printf("Unknown class type: %s", class)
+				Warnf("Unknown class type: %s", class)
 			}
 		} else {
 			for k, v := range base {
@@ -88,6 +95,9 @@ func Parse(cwl_path string) (CWLGraph, error) {
 	err = yaml.Unmarshal(source, &doc)
 	x, _ := filepath.Abs(cwl_path)
 	parser := CWLParser{Path: x, Schemas: make(map[string]Schema), Elements: make(map[string]CWLDoc)}
+	if v, ok := doc["cwlVersion"].(string); ok {
+		parser.CWLVersion = v
+	}
 	if base, ok := doc["$graph"]; ok {
 		return parser.NewGraph(base)
 	} else if _, ok := doc["class"]; ok {
@@ -97,9 +107,10 @@ func Parse(cwl_path string) (CWLGraph, error) {
 }
 
 type CWLParser struct {
-	Path     string
-	Schemas  map[string]Schema
-	Elements map[string]CWLDoc
+	Path       string
+	CWLVersion string
+	Schemas    map[string]Schema
+	Elements   map[string]CWLDoc
 }
 
 func (self *CWLParser) AddSchema(schema Schema) {
@@ -111,7 +122,7 @@ func (self *CWLParser) GetElement(path string) (CWLDoc, error) {
 		return i, nil
 	} else {
 		if strings.HasPrefix(path, "#") {
-			log.Printf("Need to parse another part of the graph: %s%s", self.Path, path)
+			Debugf("Need to parse another part of the graph: %s%s", self.Path, path)
 			source, err := ioutil.ReadFile(self.Path)
 			if err != nil {
 				return nil, fmt.Errorf("Unable to parse file: %s", err)
@@ -125,7 +136,7 @@ func (self *CWLParser) GetElement(path string) (CWLDoc, error) {
 					for _, i := range base {
 						if bmap, ok := i.(map[interface{}]interface{}); ok {
 							if bmap["id"] == p || bmap["id"] == "#"+p {
-								log.Printf("Found it")
+								Debugf("Found it")
 								c, err := parser.NewClass(bmap)
 								if err != nil {
 									return nil, err
@@ -171,7 +182,7 @@ func (self *CWLParser) NewGraph(graph interface{}) (CWLGraph, error) {
 	docs := CWLGraph{Elements: map[string]CWLDoc{}}
 	if base, ok := graph.([]interface{}); ok {
 		for _, i := range base {
-			parser := CWLParser{Path: self.Path, Schemas: make(map[string]Schema), Elements: make(map[string]CWLDoc)}
+			parser := CWLParser{Path: self.Path, CWLVersion: self.CWLVersion, Schemas: make(map[string]Schema), Elements: make(map[string]CWLDoc)}
 			if classBase, ok := i.(map[interface{}]interface{}); ok {
 				cDoc, err := parser.NewClass(classBase)
 				if err != nil {
@@ -180,7 +191,7 @@ func (self *CWLParser) NewGraph(graph interface{}) (CWLGraph, error) {
 				for k, v := range cDoc.Elements {
 					docs.Elements[k] = v
 				}
-				log.Printf("Parsing Graph %#v", i)
+				Debugf("Parsing Graph %#v", i)
 			}
 		}
 	}
@@ -188,7 +199,7 @@ func (self *CWLParser) NewGraph(graph interface{}) (CWLGraph, error) {
 }
 
 func (self *CWLParser) NewWorkflow(doc map[interface{}]interface{}) (CWLGraph, error) {
-	log.Printf("Workflow: %v", doc)
+	Debugf("Workflow: %v", doc)
 	out := Workflow{}
 	if _, ok := doc["id"]; ok {
 		out.Id = doc["id"].(string)
@@ -271,7 +282,7 @@ func (self *CWLParser) NewWorkflow(doc map[interface{}]interface{}) (CWLGraph, e
 }
 
 func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLGraph, error) {
-	log.Printf("CommandLineTool: %v", doc)
+	Debugf("CommandLineTool: %v", doc)
 	out := CommandLineTool{}
 	out.Inputs = make(map[string]CommandInput)
 	out.Outputs = make(map[string]CommandOutput)
@@ -288,7 +299,7 @@ func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLG
 		if err != nil {
 			return CWLGraph{}, err
 		}
-		log.Printf("Requirements: %#v", r)
+		Debugf("Requirements: %#v", r)
 		out.Requirements = append(out.Requirements, r...)
 	}
 
@@ -298,10 +309,16 @@ func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLG
 		if err != nil {
 			return CWLGraph{}, err
 		}
-		log.Printf("Hints: %#v", r)
+		Debugf("Hints: %#v", r)
 		out.Requirements = append(out.Requirements, r...)
 	}
 
+	//v1.0 tools expect Directory inputs to be listed
+	out.LoadListing = NO_LISTING
+	if self.CWLVersion == "" || self.CWLVersion == "v1.0" {
+		out.LoadListing = DEEP_LISTING
+	}
+
 	/* BaseCommand */
 	if base, ok := doc["baseCommand"].([]interface{}); ok {
 		o := make([]string, len(base))
@@ -337,7 +354,7 @@ func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLG
 				}
 			}
 		} else if base_array, ok := base.([]interface{}); ok {
-			log.Printf("Input array: %d", len(base_array))
+			Debugf("Input array: %d", len(base_array))
 			for _, x := range base_array {
 				n, err := self.NewCommandInput("", x)
 				if err == nil {
@@ -347,10 +364,10 @@ func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLG
 				}
 			}
 		} else {
-			log.Printf("Can't Parse Inputs")
+			Warnf("Can't Parse Inputs")
 		}
 	} else {
-		log.Printf("No Inputs found")
+		Debugf("No Inputs found")
 	}
 
 	/* Outputs */
@@ -364,7 +381,7 @@ func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLG
 				out.Outputs[n.Id] = n
 			}
 		} else if base_array, ok := base.([]interface{}); ok {
-			log.Printf("Output array: %d", len(base_array))
+			Debugf("Output array: %d", len(base_array))
 			for _, x := range base_array {
 				n, err := self.NewCommandOutput("", x)
 				if err != nil {
@@ -373,10 +390,10 @@ func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLG
 				out.Outputs[n.Id] = n
 			}
 		} else {
-			log.Printf("Can't Parse Outputs")
+			Warnf("Can't Parse Outputs")
 		}
 	} else {
-		log.Printf("No Outputs found")
+		Debugf("No Outputs found")
 	}
 
 	if base, ok := doc["stderr"]; ok {
@@ -388,6 +405,17 @@ func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLG
 	if base, ok := doc["stdin"]; ok {
 		out.Stdin = base.(string)
 	}
+	//an input of type stdin is a File that is also read as stdin
+	for k, v := range out.Inputs {
+		if v.TypeName == "stdin" {
+			if out.Stdin != "" {
+				return CWLGraph{}, fmt.Errorf("Input %s of type stdin conflicts with stdin %s", k, out.Stdin)
+			}
+			v.TypeName = "File"
+			out.Inputs[k] = v
+			out.Stdin = fmt.Sprintf("$(inputs.%s.path)", k)
+		}
+	}
 
 	if base, ok := doc["successCodes"]; ok {
 		out.SuccessCodes = []int{}
@@ -398,12 +426,12 @@ func (self *CWLParser) NewCommandLineTool(doc map[interface{}]interface{}) (CWLG
 		}
 	}
 
-	log.Printf("Parse CommandLineTool: %v", out)
+	Debugf("Parse CommandLineTool: %v", out)
 	return CWLGraph{Elements: map[string]CWLDoc{out.Id: out}, Main: out.Id}, nil
 }
 
 func (self *CWLParser) NewExpressionTool(doc map[interface{}]interface{}) (CWLGraph, error) {
-	log.Printf("ExpressionTool: %v", doc)
+	Debugf("ExpressionTool: %v", doc)
 	out := ExpressionTool{}
 	out.Inputs = make(map[string]ExpressionInput)
 	out.Outputs = make(map[string]ExpressionOutput)
@@ -420,7 +448,7 @@ func (self *CWLParser) NewExpressionTool(doc map[interface{}]interface{}) (CWLGr
 		if err != nil {
 			return CWLGraph{}, err
 		}
-		log.Printf("Requirements: %#v", r)
+		Debugf("Requirements: %#v", r)
 		out.Requirements = r
 	}
 
@@ -438,7 +466,7 @@ func (self *CWLParser) NewExpressionTool(doc map[interface{}]interface{}) (CWLGr
 				}
 			}
 		} else if base_array, ok := base.([]interface{}); ok {
-			log.Printf("Input array: %d", len(base_array))
+			Debugf("Input array: %d", len(base_array))
 			for _, x := range base_array {
 				n, err := self.NewExpressionInput("", x)
 				if err == nil {
@@ -448,10 +476,10 @@ func (self *CWLParser) NewExpressionTool(doc map[interface{}]interface{}) (CWLGr
 				}
 			}
 		} else {
-			log.Printf("Can't Parse Inputs")
+			Warnf("Can't Parse Inputs")
 		}
 	} else {
-		log.Printf("No Inputs found")
+		Debugf("No Inputs found")
 	}
 
 	/* Outputs */
@@ -465,7 +493,7 @@ func (self *CWLParser) NewExpressionTool(doc map[interface{}]interface{}) (CWLGr
 				out.Outputs[n.Id] = n
 			}
 		} else if base_array, ok := base.([]interface{}); ok {
-			log.Printf("Output array: %d", len(base_array))
+			Debugf("Output array: %d", len(base_array))
 			for _, x := range base_array {
 				n, err := self.NewExpressionOutput("", x)
 				if err != nil {
@@ -474,13 +502,13 @@ func (self *CWLParser) NewExpressionTool(doc map[interface{}]interface{}) (CWLGr
 				out.Outputs[n.Id] = n
 			}
 		} else {
-			log.Printf("Can't Parse Outputs")
+			Warnf("Can't Parse Outputs")
 		}
 	} else {
-		log.Printf("No Outputs found")
+		Debugf("No Outputs found")
 	}
 
-	log.Printf("Parse ExpressionTool: %v", out)
+	Debugf("Parse ExpressionTool: %v", out)
 	return CWLGraph{Elements: map[string]CWLDoc{out.Id: out}, Main: out.Id}, nil
 }
 
@@ -541,7 +569,7 @@ func (self *CWLParser) NewStep(id string, x interface{}) (Step, error) {
 			}
 			sout.In = inputs
 		} else {
-			log.Printf("Step %s has no inputs", sout.Id)
+			Debugf("Step %s has no inputs", sout.Id)
 		}
 
 		if bOut, ok := base["out"]; ok {
@@ -557,12 +585,12 @@ func (self *CWLParser) NewStep(id string, x interface{}) (Step, error) {
 			}
 			sout.Out = outputs
 		} else {
-			log.Printf("Step %s has no output", sout.Id)
+			Debugf("Step %s has no output", sout.Id)
 		}
 
 		if bRun, ok := base["run"]; ok {
 			if r, ok := bRun.(string); ok {
-				log.Printf("StepRun: %s", r)
+				Debugf("StepRun: %s", r)
 				doc, err := self.GetElement(r)
 				if err != nil {
 					return sout, fmt.Errorf("Unable to parse step %s: %s", sout.Id, err)
@@ -642,6 +670,8 @@ func (self *CWLParser) NewStepInput(id string, x interface{}) (StepInput, error)
 		if source, ok := base["source"]; ok {
 			out.Source = source.(string)
 		}
+		//step inputs have no secondaryFiles, they come from the input
+		//parameters of the tool the step runs
 		//duplicate code as the schema, need to figure out how to merge this logic....
 		if def, ok := base["default"]; ok {
 			out.Default = &def
@@ -649,10 +679,10 @@ func (self *CWLParser) NewStepInput(id string, x interface{}) (StepInput, error)
 				//special case when default value is a file
 				if base, ok := def.(map[interface{}]interface{}); ok {
 					if s, ok := base["path"]; ok {
-						base["path"] = path.Join(filepath.Dir(self.Path), s.(string))
+						base["path"] = ResolveLocation(s.(string), filepath.Dir(self.Path))
 					}
 					if s, ok := base["location"]; ok {
-						base["location"] = path.Join(filepath.Dir(self.Path), s.(string))
+						base["location"] = ResolveLocation(s.(string), filepath.Dir(self.Path))
 					}
 				}
 			}
@@ -700,7 +730,7 @@ func (self *CWLParser) NewCommandInput(id string, x interface{}) (CommandInput,
 }
 
 func (self *CWLParser) NewCommandOutput(id string, x interface{}) (CommandOutput, error) {
-	log.Printf("CommandOutput parse")
+	Debugf("CommandOutput parse")
 	t, err := self.NewSchema(x)
 	if err != nil {
 		return CommandOutput{}, fmt.Errorf("unable to load schema: %s", err)
@@ -713,12 +743,25 @@ func (self *CWLParser) NewCommandOutput(id string, x interface{}) (CommandOutput
 	if base, ok := x.(map[interface{}]interface{}); ok {
 		if _, ok := base["outputBinding"]; ok {
 			if bindBase, ok := base["outputBinding"].(map[interface{}]interface{}); ok {
-				if _, ok := bindBase["glob"]; ok {
-					g := bindBase["glob"].(string)
-					out.Glob = g
+				if g, ok := bindBase["glob"]; ok {
+					if gs, ok := g.(string); ok {
+						out.Glob = []string{gs}
+					} else if ga, ok := g.([]interface{}); ok {
+						for _, i := range ga {
+							out.Glob = append(out.Glob, i.(string))
+						}
+					} else {
+						return out, fmt.Errorf("Unable to parse glob: %#v", g)
+					}
+				}
+				if loadContents, ok := bindBase["loadContents"].(bool); ok {
+					out.LoadContents = loadContents
+				}
+				if outputEval, ok := bindBase["outputEval"].(string); ok {
+					out.OutputEval = outputEval
 				}
 			} else {
-				log.Printf("Output Binding format weird")
+				Warnf("Output Binding format weird")
 			}
 		} else if bType, ok := base["type"]; ok {
 			if bType == "stdout" {
@@ -726,13 +769,13 @@ func (self *CWLParser) NewCommandOutput(id string, x interface{}) (CommandOutput
 			} else if bType == "stderr" {
 				//do nothing here, just avoiding warning logging
 			} else {
-				log.Printf("Unknown output binding: %s %s", out.Id, base)
+				Warnf("Unknown output binding: %s %s", out.Id, base)
 			}
 		} else {
-			log.Printf("No output binding: %s %s", out.Id, base)
+			Debugf("No output binding: %s %s", out.Id, base)
 		}
 	} else if base, ok := x.(string); ok {
-		log.Printf("output schema string: %s", base)
+		Debugf("output schema string: %s", base)
 	} else {
 		return out, fmt.Errorf("Unable to parse CommandOutput: %v", x)
 	}
@@ -740,7 +783,7 @@ func (self *CWLParser) NewCommandOutput(id string, x interface{}) (CommandOutput
 }
 
 func (self *CWLParser) NewExpressionInput(id string, x interface{}) (ExpressionInput, error) {
-	log.Printf("ExpressionInput parse")
+	Debugf("ExpressionInput parse")
 	t, err := self.NewSchema(x)
 	if err != nil {
 		return ExpressionInput{}, fmt.Errorf("unable to load schema: %s", err)
@@ -753,7 +796,7 @@ func (self *CWLParser) NewExpressionInput(id string, x interface{}) (ExpressionI
 }
 
 func (self *CWLParser) NewExpressionOutput(id string, x interface{}) (ExpressionOutput, error) {
-	log.Printf("ExpressionOutput parse")
+	Debugf("ExpressionOutput parse")
 	t, err := self.NewSchema(x)
 	if err != nil {
 		return ExpressionOutput{}, fmt.Errorf("unable to load schema: %s", err)
@@ -768,6 +811,9 @@ func (self *CWLParser) NewExpressionOutput(id string, x interface{}) (Expression
 var SCHEMA_TYPES = map[string]bool{
 	"boolean":   true,
 	"int":       true,
+	"long":      true,
+	"float":     true,
+	"double":    true,
 	"array":     true,
 	"record":    true,
 	"File":      true,
@@ -776,18 +822,34 @@ var SCHEMA_TYPES = map[string]bool{
 	"string":    true,
 	"stdout":    true,
 	"stderr":    true,
+	"stdin":     true,
 	"Any":       true,
 }
 
 func (self *CWLParser) NewSchema(value interface{}) (Schema, error) {
 
 	if base, ok := value.(string); ok {
+		//type shorthands: 'File?' is [null, File], 'File[]' an array of File
+		if strings.HasSuffix(base, "?") {
+			t, err := self.NewSchema(base[:len(base)-1])
+			if err != nil {
+				return Schema{}, err
+			}
+			return Schema{Types: []Schema{{TypeName: "null"}, t}}, nil
+		}
+		if strings.HasSuffix(base, "[]") {
+			t, err := self.NewSchema(base[:len(base)-2])
+			if err != nil {
+				return Schema{}, err
+			}
+			return Schema{TypeName: "array", Items: &t}, nil
+		}
 		if _, found := SCHEMA_TYPES[base]; !found {
-			log.Printf("Schema not found: %s", base)
+			Debugf("Schema not found: %s", base)
 			if _, ok := self.Schemas[base[1:]]; ok {
-				log.Printf("Schema Found")
+				Debugf("Schema Found")
 			} else {
-				log.Printf("Not found in %#v", self.Schemas)
+				Debugf("Not found in %#v", self.Schemas)
 			}
 			return Schema{}, fmt.Errorf("Schema not found: %s", base)
 		} else {
@@ -830,21 +892,28 @@ func (self *CWLParser) NewSchema(value interface{}) (Schema, error) {
 		}
 
 		if binding, ok := base["inputBinding"]; ok {
-			out.Bound = true
-			if pos, ok := binding.(map[interface{}]interface{})["position"]; ok {
-				out.Position = pos.(int)
-			} else {
-				out.Position = 100000
-			}
-			if prefix, ok := binding.(map[interface{}]interface{})["prefix"].(string); ok {
-				out.Prefix = prefix
+			if err := self.NewBinding(binding, &out); err != nil {
+				return out, err
 			}
-			if itemSep, ok := binding.(map[interface{}]interface{})["itemSeparator"].(string); ok {
-				out.ItemSeparator = itemSep
+		}
+
+		if format, ok := base["format"].(string); ok {
+			out.Format = format
+		}
+
+		if l, ok := base["loadListing"].(string); ok {
+			if !LOAD_LISTING_VALUES[l] {
+				return out, fmt.Errorf("Unknown loadListing: %s", l)
 			}
-			if loadContents, ok := binding.(map[interface{}]interface{})["loadContents"].(bool); ok {
-				out.LoadContents = loadContents
+			out.LoadListing = l
+		}
+
+		if sf, ok := base["secondaryFiles"]; ok {
+			secondary, err := self.NewSecondaryFiles(sf)
+			if err != nil {
+				return out, err
 			}
+			out.SecondaryFiles = secondary
 		}
 
 		if def, ok := base["default"]; ok {
@@ -853,24 +922,32 @@ func (self *CWLParser) NewSchema(value interface{}) (Schema, error) {
 			if out.TypeName == "File" {
 				if base, ok := def.(map[interface{}]interface{}); ok {
 					if s, ok := base["path"]; ok {
-						base["path"] = path.Join(filepath.Dir(self.Path), s.(string))
+						base["path"] = ResolveLocation(s.(string), filepath.Dir(self.Path))
 					}
 					if s, ok := base["location"]; ok {
-						base["location"] = path.Join(filepath.Dir(self.Path), s.(string))
+						base["location"] = ResolveLocation(s.(string), filepath.Dir(self.Path))
 					}
 				}
 			}
 		}
 
+		if fields, ok := base["fields"]; ok {
+			f, err := self.NewFields(fields)
+			if err != nil {
+				return out, err
+			}
+			out.Fields = f
+		}
+
 		if bItem, ok := base["items"]; ok {
 			a, err := self.NewSchema(bItem)
 			if err != nil {
 				return out, fmt.Errorf("Can't parse items")
 			}
-			log.Printf("Items Schema: %#v", a)
+			Debugf("Items Schema: %#v", a)
 			out.Items = &a
 		}
-		log.Printf("NewSchema: %#v", out)
+		Debugf("NewSchema: %#v", out)
 		return out, nil
 	} else {
 		return Schema{}, fmt.Errorf("Unknown data type: %#v", value)
@@ -878,24 +955,124 @@ func (self *CWLParser) NewSchema(value interface{}) (Schema, error) {
 	return Schema{}, nil
 }
 
-func (self *CWLParser) NewArgument(x interface{}) (Argument, error) {
-	if base, ok := x.(string); ok {
-		return Argument{Value: &base, Schema: Schema{Bound: true}}, nil
+// NewBinding reads the fields of a CommandLineBinding into out
+func (self *CWLParser) NewBinding(x interface{}, out *Schema) error {
+	binding, ok := x.(map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("Unable to parse binding: %#v", x)
+	}
+	out.Bound = true
+	if pos, ok := binding["position"].(int); ok {
+		out.Position = pos
+	} else if pos, ok := binding["position"]; ok {
+		return fmt.Errorf("Unsupported binding position: %#v", pos)
+	}
+	if prefix, ok := binding["prefix"].(string); ok {
+		out.Prefix = prefix
+	}
+	if itemSep, ok := binding["itemSeparator"].(string); ok {
+		out.ItemSeparator = itemSep
+	}
+	if loadContents, ok := binding["loadContents"].(bool); ok {
+		out.LoadContents = loadContents
+	}
+	if shellQuote, ok := binding["shellQuote"].(bool); ok {
+		out.NoShellQuote = !shellQuote
+	}
+	if separate, ok := binding["separate"].(bool); ok {
+		out.NoSeparate = !separate
+	}
+	if valueFrom, ok := binding["valueFrom"].(string); ok {
+		out.ValueFrom = valueFrom
+	}
+	return nil
+}
+
+// NewFields parses the fields of a record type, given as a list of field
+// definitions or a map of field names to types or definitions
+func (self *CWLParser) NewFields(x interface{}) ([]Schema, error) {
+	out := []Schema{}
+	if base, ok := x.([]interface{}); ok {
+		for _, i := range base {
+			f, err := self.NewSchema(i)
+			if err != nil {
+				return out, fmt.Errorf("Unable to parse record field: %s", err)
+			}
+			if f.Name == "" {
+				return out, fmt.Errorf("Record field without name: %#v", i)
+			}
+			out = append(out, f)
+		}
+		return out, nil
 	}
 	if base, ok := x.(map[interface{}]interface{}); ok {
-		out := Argument{Schema: Schema{Bound: true}}
-		if x, ok := base["valueFrom"]; ok {
-			s := x.(string)
-			out.ValueFrom = &s
+		for k, v := range base {
+			if _, ok := v.(map[interface{}]interface{}); !ok {
+				v = map[interface{}]interface{}{"type": v}
+			}
+			f, err := self.NewSchema(v)
+			if err != nil {
+				return out, fmt.Errorf("Unable to parse record field %s: %s", k, err)
+			}
+			f.Name = k.(string)
+			out = append(out, f)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+		return out, nil
+	}
+	return out, fmt.Errorf("Unable to parse record fields: %#v", x)
+}
+
+// NewSecondaryFiles parses a secondaryFiles field: a pattern, a
+// {pattern, required} record or a list of either. A pattern ending in '?'
+// is optional.
+func (self *CWLParser) NewSecondaryFiles(x interface{}) ([]SecondaryFile, error) {
+	out := []SecondaryFile{}
+	if base, ok := x.([]interface{}); ok {
+		for _, i := range base {
+			s, err := self.NewSecondaryFiles(i)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, s...)
+		}
+		return out, nil
+	}
+	if base, ok := x.(string); ok {
+		if strings.HasSuffix(base, "?") {
+			return append(out, SecondaryFile{Pattern: base[:len(base)-1], Required: "false"}), nil
 		}
-		if x, ok := base["position"]; ok {
-			out.Position = x.(int)
+		return append(out, SecondaryFile{Pattern: base}), nil
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		sf := SecondaryFile{}
+		if p, ok := base["pattern"].(string); ok {
+			sf.Pattern = p
 		} else {
-			out.Position = 10000
+			return out, fmt.Errorf("secondaryFiles entry without pattern: %#v", x)
 		}
-		if x, ok := base["prefix"]; ok {
-			x_s := x.(string)
-			out.Prefix = x_s
+		if r, ok := base["required"]; ok {
+			if b, ok := r.(bool); ok {
+				sf.Required = fmt.Sprintf("%t", b)
+			} else if s, ok := r.(string); ok {
+				sf.Required = s
+			} else {
+				return out, fmt.Errorf("Unable to parse secondaryFiles required: %#v", r)
+			}
+		}
+		return append(out, sf), nil
+	}
+	return out, fmt.Errorf("Unable to parse secondaryFiles: %#v", x)
+}
+
+func (self *CWLParser) NewArgument(x interface{}) (Argument, error) {
+	if base, ok := x.(string); ok {
+		return Argument{Schema: Schema{Bound: true, ValueFrom: base}}, nil
+	}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		out := Argument{}
+		if err := self.NewBinding(base, &out.Schema); err != nil {
+			return out, err
 		}
 		return out, nil
 	}
@@ -967,7 +1144,7 @@ func (self *CWLParser) NewHints(x interface{}) ([]Requirement, error) {
 }
 
 func (self *CWLParser) NewRequirement(id_string string, conf interface{}) (Requirement, error) {
-	log.Printf("Requirement: %s", id_string)
+	Debugf("Requirement: %s", id_string)
 	switch {
 	case id_string == "SchemaDefRequirement":
 		schemaRequirement, err := self.NewSchemaDefRequirement(conf)
@@ -986,8 +1163,20 @@ func (self *CWLParser) NewRequirement(id_string string, conf interface{}) (Requi
 		return self.NewDockerRequirement(conf)
 	case id_string == "ResourceRequirement":
 		return self.NewResourceRequirement(conf)
+	case id_string == "ToolTimeLimit":
+		return self.NewToolTimeLimit(conf)
+	case id_string == "NetworkAccess":
+		return self.NewNetworkAccess(conf)
+	case id_string == "WorkReuse":
+		return self.NewWorkReuseRequirement(conf)
+	case id_string == "EnvVarRequirement":
+		return self.NewEnvVarRequirement(conf)
+	case id_string == "ShellCommandRequirement":
+		return ShellCommandRequirement{}, nil
+	case strings.HasSuffix(id_string, "LoadListingRequirement"):
+		return self.NewLoadListingRequirement(conf)
 	default:
-		log.Printf("Unsupported Requirement %s", id_string)
+		Warnf("Unsupported Requirement %s", id_string)
 		e := UnsupportedRequirement{Message: fmt.Sprintf("Unknown requirement: %s", id_string)}
 		return nil, e
 	}
@@ -1015,35 +1204,220 @@ func (self *CWLParser) NewSchemaDefRequirement(conf interface{}) (SchemaDefRequi
 }
 
 func (self *CWLParser) NewResourceRequirement(conf interface{}) (ResourceRequirement, error) {
-	props := map[string]interface{}{}
-	if base, ok := conf.(map[interface{}]interface{}); ok {
-		for k, v := range base {
-			key := k.(string)
-			if key != "class" {
-				props[key] = v
+	out := ResourceRequirement{}
+	base, ok := conf.(map[interface{}]interface{})
+	if !ok {
+		return out, fmt.Errorf("Unable to parse ResourceRequirement: %#v", conf)
+	}
+	fields := map[string]*string{
+		"coresMin":  &out.CoresMin,
+		"coresMax":  &out.CoresMax,
+		"ramMin":    &out.RamMin,
+		"ramMax":    &out.RamMax,
+		"tmpdirMin": &out.TmpdirMin,
+		"tmpdirMax": &out.TmpdirMax,
+		"outdirMin": &out.OutdirMin,
+		"outdirMax": &out.OutdirMax,
+	}
+	for k, p := range fields {
+		if v, ok := base[k]; ok {
+			switch v.(type) {
+			case int, float64, string:
+				*p = fmt.Sprintf("%v", v)
+			default:
+				return out, fmt.Errorf("Unable to parse ResourceRequirement %s: %#v", k, v)
 			}
 		}
 	}
-	return ResourceRequirement{
-		Props: props,
-	}, nil
+	return out, nil
+}
+
+func (self *CWLParser) NewToolTimeLimit(conf interface{}) (ToolTimeLimit, error) {
+	base, ok := conf.(map[interface{}]interface{})
+	if !ok {
+		return ToolTimeLimit{}, fmt.Errorf("Unable to parse ToolTimeLimit: %#v", conf)
+	}
+	switch v := base["timelimit"].(type) {
+	case int:
+		if v < 0 {
+			return ToolTimeLimit{}, fmt.Errorf("ToolTimeLimit timelimit is negative: %d", v)
+		}
+		return ToolTimeLimit{TimeLimit: fmt.Sprintf("%d", v)}, nil
+	case string:
+		return ToolTimeLimit{TimeLimit: v}, nil
+	}
+	return ToolTimeLimit{}, fmt.Errorf("Unable to parse ToolTimeLimit timelimit: %#v", base["timelimit"])
 }
 
 func (self *CWLParser) NewDockerRequirement(x interface{}) (DockerRequirement, error) {
-	if base, ok := x.(map[interface{}]interface{}); ok {
-		if pull, ok := base["dockerPull"]; ok {
-			return DockerRequirement{
-				DockerPull: pull.(string),
-			}, nil
+	out := DockerRequirement{}
+	base, ok := x.(map[interface{}]interface{})
+	if !ok {
+		return out, fmt.Errorf("Unable to parse DockerRequirement: %#v", x)
+	}
+	fields := map[string]*string{
+		"dockerPull":            &out.DockerPull,
+		"dockerLoad":            &out.DockerLoad,
+		"dockerFile":            &out.DockerFile,
+		"dockerImport":          &out.DockerImport,
+		"dockerImageId":         &out.DockerImageId,
+		"dockerOutputDirectory": &out.DockerOutputDirectory,
+	}
+	for k, p := range fields {
+		if v, ok := base[k]; ok {
+			s, ok := v.(string)
+			if !ok {
+				return out, fmt.Errorf("Unable to parse DockerRequirement %s: %#v", k, v)
+			}
+			*p = s
+		}
+	}
+	//files to load or import are found next to the document, URLs are
+	//fetched as they are
+	for _, p := range []*string{&out.DockerLoad, &out.DockerImport} {
+		if *p != "" && (!strings.Contains(*p, "://") || strings.HasPrefix(*p, "file://")) {
+			*p = ResolveLocation(*p, filepath.Dir(self.Path))
 		}
 	}
-	return DockerRequirement{}, fmt.Errorf("DockerPull not found")
+	if out.DockerPull == "" && out.DockerLoad == "" && out.DockerFile == "" && out.DockerImport == "" && out.DockerImageId == "" {
+		return out, fmt.Errorf("DockerRequirement needs one of dockerPull, dockerLoad, dockerFile, dockerImport or dockerImageId")
+	}
+	if out.DockerOutputDirectory != "" && !filepath.IsAbs(out.DockerOutputDirectory) {
+		return out, fmt.Errorf("dockerOutputDirectory must be an absolute path: %s", out.DockerOutputDirectory)
+	}
+	return out, nil
 }
 
 func (self *CWLParser) NewInlineJavascriptRequirement(x interface{}) (InlineJavascriptRequirement, error) {
 	return InlineJavascriptRequirement{}, nil
 }
 
+func (self *CWLParser) NewWorkReuseRequirement(x interface{}) (WorkReuseRequirement, error) {
+	out := WorkReuseRequirement{EnableReuse: "true"}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if e, ok := base["enableReuse"]; ok {
+			if b, ok := e.(bool); ok {
+				out.EnableReuse = fmt.Sprintf("%t", b)
+			} else if s, ok := e.(string); ok {
+				out.EnableReuse = s
+			} else {
+				return out, fmt.Errorf("Unable to parse enableReuse: %#v", e)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (self *CWLParser) NewNetworkAccess(x interface{}) (NetworkAccess, error) {
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		switch e := base["networkAccess"].(type) {
+		case bool:
+			return NetworkAccess{NetworkAccess: fmt.Sprintf("%t", e)}, nil
+		case string:
+			return NetworkAccess{NetworkAccess: e}, nil
+		}
+	}
+	return NetworkAccess{}, fmt.Errorf("Unable to parse networkAccess: %#v", x)
+}
+
+func (self *CWLParser) NewEnvVarRequirement(x interface{}) (EnvVarRequirement, error) {
+	out := EnvVarRequirement{EnvDef: map[string]string{}}
+	base, ok := x.(map[interface{}]interface{})
+	if !ok {
+		return out, fmt.Errorf("Unable to parse EnvVarRequirement: %#v", x)
+	}
+	if defs, ok := base["envDef"].([]interface{}); ok {
+		for _, i := range defs {
+			d, ok := i.(map[interface{}]interface{})
+			if !ok {
+				return out, fmt.Errorf("Unable to parse envDef: %#v", i)
+			}
+			name, ok := d["envName"].(string)
+			if !ok {
+				return out, fmt.Errorf("envDef without envName: %#v", i)
+			}
+			value, ok := d["envValue"].(string)
+			if !ok {
+				return out, fmt.Errorf("envDef %s has no string envValue", name)
+			}
+			out.EnvDef[name] = value
+		}
+	} else if defs, ok := base["envDef"].(map[interface{}]interface{}); ok {
+		for k, v := range defs {
+			if d, ok := v.(map[interface{}]interface{}); ok {
+				v = d["envValue"]
+			}
+			value, ok := v.(string)
+			if !ok {
+				return out, fmt.Errorf("envDef %s has no string envValue", k)
+			}
+			out.EnvDef[k.(string)] = value
+		}
+	} else {
+		return out, fmt.Errorf("Unable to parse envDef: %#v", base["envDef"])
+	}
+	return out, nil
+}
+
+func (self *CWLParser) NewLoadListingRequirement(x interface{}) (LoadListingRequirement, error) {
+	out := LoadListingRequirement{LoadListing: NO_LISTING}
+	if base, ok := x.(map[interface{}]interface{}); ok {
+		if l, ok := base["loadListing"].(string); ok {
+			if !LOAD_LISTING_VALUES[l] {
+				return out, fmt.Errorf("Unknown loadListing: %s", l)
+			}
+			out.LoadListing = l
+		}
+	}
+	return out, nil
+}
+
 func (self *CWLParser) NewInitialWorkDirRequirement(x interface{}) (InitialWorkDirRequirement, error) {
-	return InitialWorkDirRequirement{}, nil
+	out := InitialWorkDirRequirement{}
+	base, ok := x.(map[interface{}]interface{})
+	if !ok {
+		return out, fmt.Errorf("Unable to parse InitialWorkDirRequirement: %#v", x)
+	}
+	if s, ok := base["listing"].(string); ok {
+		out.Expression = s
+		return out, nil
+	}
+	listing, ok := base["listing"].([]interface{})
+	if !ok {
+		return out, fmt.Errorf("Unable to parse InitialWorkDirRequirement listing: %#v", base["listing"])
+	}
+	for _, i := range listing {
+		d, err := self.NewDirent(i)
+		if err != nil {
+			return out, err
+		}
+		out.Listing = append(out.Listing, d)
+	}
+	return out, nil
+}
+
+func (self *CWLParser) NewDirent(x interface{}) (Dirent, error) {
+	if s, ok := x.(string); ok {
+		return Dirent{Entry: s}, nil
+	}
+	base, ok := x.(map[interface{}]interface{})
+	if !ok {
+		return Dirent{}, fmt.Errorf("Unable to parse listing entry: %#v", x)
+	}
+	if class, ok := base["class"]; ok && (class == "File" || class == "Directory") {
+		return Dirent{Value: AdjustInputs(base, filepath.Dir(self.Path)).(map[interface{}]interface{})}, nil
+	}
+	out := Dirent{}
+	if s, ok := base["entry"].(string); ok {
+		out.Entry = s
+	} else {
+		return out, fmt.Errorf("Listing entry without entry: %#v", x)
+	}
+	if s, ok := base["entryname"].(string); ok {
+		out.Entryname = s
+	}
+	if w, ok := base["writable"].(bool); ok {
+		out.Writable = w
+	}
+	return out, nil
 }
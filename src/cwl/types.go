@@ -17,17 +17,31 @@ const (
 )
 
 type Job struct {
-	JobType      int
-	Cmd          []JobArgument
-	DockerImage  string
-	Expression   string
-	Stdout       string
-	Stderr       string
-	Stdin        string
+	JobType     int
+	Cmd         []JobArgument
+	DockerImage string
+	//how to get DockerImage when it isn't present
+	Docker     DockerRequirement
+	Expression string
+	Stdout     string
+	Stderr     string
+	Stdin      string
+	//Stdout and Stderr were generated for the stdout and stderr types
+	RandomStdout bool
+	RandomStderr bool
 	InputData    JSONDict
 	Inputs       map[string]Schema
 	Outputs      map[string]Schema
 	SuccessCodes []int
+	EnableReuse  string
+	LoadListing  string
+	WorkDir      InitialWorkDirRequirement
+	Env          map[string]string
+	ShellCommand bool
+	Resources    *ResourceRequirement
+	TimeLimit    string
+	//"true", "false" or an expression, empty when not declared
+	NetworkAccess string
 }
 
 type JSEvaluator struct {
@@ -44,8 +58,14 @@ type JobArgument struct {
 	Join     string
 	Prefix   string
 	Bound    bool
-	File     *JobFile
-	Children []JobArgument
+	//prefix and value are one word (separate: false)
+	NoSeparate bool
+	//emitted verbatim when the command is run through the shell
+	NoShellQuote bool
+	//expression replacing the value, evaluated with self bound to it
+	ValueFrom string
+	File      *JobFile
+	Children  []JobArgument
 }
 
 type JobFile struct {
@@ -55,7 +75,12 @@ type JobFile struct {
 	Dir          bool
 	Output       bool
 	LoadContents bool
-	Glob         string
+	Glob         []string
+	OutputEval   string
+	//companion files given with the input value
+	SecondaryFiles []JobFile
+	//File or Directory literal, set while the file has no location
+	Literal map[interface{}]interface{}
 }
 
 type CWLGraph struct {
@@ -91,6 +116,7 @@ type CommandLineTool struct {
 	Stderr       string
 	Stdin        string
 	SuccessCodes []int
+	LoadListing  string
 }
 
 type WorkflowInput struct {
@@ -121,17 +147,34 @@ type StepOutput struct {
 }
 
 type Schema struct {
-	Id            string
-	Name          string
-	TypeName      string
-	Items         *Schema
-	Types         []Schema
-	Prefix        string
-	Position      int
-	ItemSeparator string
-	Bound         bool
-	LoadContents  bool
-	Default       *interface{}
+	Id             string
+	Name           string
+	TypeName       string
+	Items          *Schema
+	Types          []Schema
+	Prefix         string
+	Position       int
+	ItemSeparator  string
+	Bound          bool
+	NoSeparate     bool
+	NoShellQuote   bool
+	ValueFrom      string
+	LoadContents   bool
+	SecondaryFiles []SecondaryFile
+	Format         string
+	LoadListing    string
+	Default        *interface{}
+	//fields of a record type
+	Fields []Schema
+}
+
+// SecondaryFile is a secondaryFiles pattern. Pattern is either a suffix,
+// with leading '^' characters each removing an extension from the primary
+// file name, or an expression. Required is "true", "false" or an
+// expression, empty means the default for inputs or outputs.
+type SecondaryFile struct {
+	Pattern  string
+	Required string
 }
 
 type CommandInput struct {
@@ -140,7 +183,8 @@ type CommandInput struct {
 
 type CommandOutput struct {
 	Schema
-	Glob string
+	Glob       []string
+	OutputEval string
 }
 
 type ExpressionTool struct {
@@ -173,22 +217,96 @@ type SchemaDefRequirement struct {
 	NewTypes []Schema
 }
 
+// DockerRequirement names the image a job runs in and how to get it when
+// it isn't present: pulled, loaded from a tar, built from a Dockerfile or
+// imported from a filesystem tarball. DockerOutputDirectory is where the
+// output directory is mounted in the container.
 type DockerRequirement struct {
-	DockerPull string
+	DockerPull            string
+	DockerLoad            string
+	DockerFile            string
+	DockerImport          string
+	DockerImageId         string
+	DockerOutputDirectory string
 }
 
+// ResourceRequirement holds the declared bounds, each a number or an
+// expression, empty when not given. Ram, tmpdir and outdir are in MiB.
 type ResourceRequirement struct {
-	Props map[string]interface{}
+	CoresMin  string
+	CoresMax  string
+	RamMin    string
+	RamMax    string
+	TmpdirMin string
+	TmpdirMax string
+	OutdirMin string
+	OutdirMax string
+}
+
+// ToolTimeLimit is the number of seconds a job may run for, a number or an
+// expression. 0 is no limit.
+type ToolTimeLimit struct {
+	TimeLimit string
 }
 
 type InlineJavascriptRequirement struct {
 }
 
 type InitialWorkDirRequirement struct {
+	Listing []Dirent
+	//an expression that returns the whole listing
+	Expression string
+}
+
+// Dirent is an entry of an InitialWorkDirRequirement listing. Value holds a
+// File or Directory object given in the document, otherwise Entry is the
+// text or expression to stage, under Entryname if that is set.
+type Dirent struct {
+	Entryname string
+	Entry     string
+	Value     map[interface{}]interface{}
+	Writable  bool
+}
+
+// NetworkAccess lets a job reach the network, otherwise its container is
+// isolated
+type NetworkAccess struct {
+	NetworkAccess string
+}
+
+type WorkReuseRequirement struct {
+	EnableReuse string
+}
+
+const (
+	NO_LISTING      = "no_listing"
+	SHALLOW_LISTING = "shallow_listing"
+	DEEP_LISTING    = "deep_listing"
+)
+
+var LOAD_LISTING_VALUES = map[string]bool{
+	NO_LISTING:      true,
+	SHALLOW_LISTING: true,
+	DEEP_LISTING:    true,
+}
+
+// EnvVarRequirement maps environment variable names to values, which may
+// be expressions
+type EnvVarRequirement struct {
+	EnvDef map[string]string
+}
+
+// ShellCommandRequirement runs the command line through /bin/sh, with
+// arguments quoted unless their binding sets shellQuote: false
+type ShellCommandRequirement struct {
+}
+
+type LoadListingRequirement struct {
+	LoadListing string
 }
 
+// Argument is a CommandLineBinding of the arguments list, a plain string
+// argument is its valueFrom
 type Argument struct {
 	Schema
-	Value     *string
-	ValueFrom *string
 }
@@ -2,7 +2,6 @@ package cwl
 
 import (
 	"encoding/json"
-	"log"
 	"strings"
 )
 
@@ -10,7 +9,7 @@ func (self JSONDict) ToString() []byte {
 	a := self.Normalize()
 	o, err := json.Marshal(a)
 	if err != nil {
-		log.Printf("Output Error: %s", err)
+		LogErrorf("Output Error: %s", err)
 	}
 	return o
 }
@@ -47,8 +46,13 @@ func getFilePaths(x interface{}) []string {
 	out := []string{}
 	if a, ok := x.(JSONDict); ok {
 		if c, ok := a["class"]; ok {
-			if c.(string) == "File" {
-				out = append(out, a["path"].(string))
+			if c.(string) == "File" || c.(string) == "Directory" {
+				if p, ok := a["path"].(string); ok {
+					out = append(out, p)
+				}
+			}
+			if sf, ok := a["secondaryFiles"]; ok {
+				out = append(out, getFilePaths(sf)...)
 			}
 		} else {
 			for _, v := range a {
@@ -58,8 +62,13 @@ func getFilePaths(x interface{}) []string {
 	}
 	if a, ok := x.(map[interface{}]interface{}); ok {
 		if c, ok := a["class"]; ok {
-			if c.(string) == "File" {
-				out = append(out, a["path"].(string))
+			if c.(string) == "File" || c.(string) == "Directory" {
+				if p, ok := a["path"].(string); ok {
+					out = append(out, p)
+				}
+			}
+			if sf, ok := a["secondaryFiles"]; ok {
+				out = append(out, getFilePaths(sf)...)
 			}
 		} else {
 			for _, v := range a {
@@ -91,7 +100,7 @@ func (self JSONDict) GetFilePaths() []string {
 }
 
 func (self JSONDict) GetData(path string) (interface{}, bool) {
-	//log.Printf("Get Search: %s", path)
+	//Debugf("Get Search: %s", path)
 	tmp := strings.Split(path, "/")
 	if len(tmp) == 1 {
 		/*
@@ -117,12 +126,29 @@ func (self JSONDict) GetData(path string) (interface{}, bool) {
 }
 
 func (self *JobFile) ToJSONDict() JSONDict {
+	if self.Location == "" && self.Literal != nil {
+		a := JSONDict{}
+		for k, v := range self.Literal {
+			a[k] = v
+		}
+		return a
+	}
 	a := JSONDict{
 		"class":    "File",
 		"location": self.Location,
 	}
+	if self.Dir {
+		a["class"] = "Directory"
+	}
 	if self.LoadContents {
 		a["loadContents"] = true
 	}
+	if len(self.SecondaryFiles) > 0 {
+		sf := []interface{}{}
+		for _, i := range self.SecondaryFiles {
+			sf = append(sf, map[interface{}]interface{}(i.ToJSONDict()))
+		}
+		a["secondaryFiles"] = sf
+	}
 	return a
 }
@@ -2,7 +2,6 @@ package cwl
 
 import (
 	"fmt"
-	"log"
 	"strings"
 )
 
@@ -14,7 +13,7 @@ func (self Workflow) ReadySteps(state JSONDict) []string {
 	out := []string{}
 	for k, v := range self.Steps {
 		if v.Ready(state) {
-			log.Printf("Step Ready: %#v %#v", k, v.In)
+			Debugf("Step Ready: %#v %#v", k, v.In)
 			out = append(out, k)
 		}
 	}
@@ -43,7 +42,7 @@ func (self Workflow) Done(state JSONDict) bool {
 			done = false
 		}
 	}
-	log.Printf("CheckDone: %#v", done)
+	Debugf("CheckDone: %#v", done)
 	return done
 }
 
@@ -61,16 +60,24 @@ func (self Workflow) GetIDs() []string {
 	for _, k := range self.Steps {
 		out = append(out, k.Id)
 	}
-	log.Printf("Workflow IDs: %#v", out)
+	Debugf("Workflow IDs: %#v", out)
 	return out
 }
 
+// GetResults collects the workflow outputs from the graph state. A source
+// naming a step output is read from the results of that step's document,
+// the way Step.Ready finds step inputs, other sources are workflow inputs.
 func (self Workflow) GetResults(state JSONDict) JSONDict {
-	log.Printf("Workflow Results: %#v", state)
+	Debugf("Workflow Results: %#v", state)
 	out := JSONDict{}
 	for k, v := range self.Outputs {
-		log.Printf("Workflow Output: %#v", v)
-		out[k], _ = state.GetData(v.OutputSource)
+		Debugf("Workflow Output: %#v", v)
+		tmp := strings.Split(strings.TrimPrefix(v.OutputSource, "#"), "/")
+		if len(tmp) == 1 {
+			out[k], _ = state.GetData(fmt.Sprintf("%s/%s", INPUT_FIELD, tmp[0]))
+		} else if b, ok := state[tmp[0]].(JSONDict); ok {
+			out[k] = self.Steps[tmp[0]].Doc.GetResults(b)[tmp[1]]
+		}
 	}
 	return out
 }
@@ -89,7 +96,7 @@ func (self Workflow) GetDefault(name string) (*interface{}, bool) {
 
 func (self Step) Ready(state JSONDict) bool {
 	if _, ok := state.GetData(fmt.Sprintf("%s/%s", self.Id, RESULTS_FIELD)); ok {
-		log.Printf("Step %s done", self.Id)
+		Debugf("Step %s done", self.Id)
 		return false
 	}
 	ready := true
@@ -103,7 +110,7 @@ func (self Step) Ready(state JSONDict) bool {
 		} else {
 			if b, ok := state[tmp[0]]; ok {
 				o := self.Doc.GetResults(b.(JSONDict))
-				log.Printf("Checking for %s in results %s", tmp[1], o)
+				Debugf("Checking for %s in results %s", tmp[1], o)
 				if _, ok := o[tmp[1]]; ok {
 					found = true
 				}
@@ -113,13 +120,13 @@ func (self Step) Ready(state JSONDict) bool {
 			if v.Default == nil {
 				if _, ok := self.Parent.GetDefault(v.Source); !ok {
 					ready = false
-					log.Printf("Step %s input %s not found in %#v", self.Id, v.Source, state)
+					Debugf("Step %s input %s not found in %#v", self.Id, v.Source, state)
 				} else {
-					log.Printf("Step %s input %s has default", self.Id, v.Source)
+					Debugf("Step %s input %s has default", self.Id, v.Source)
 				}
 			}
 		} else {
-			log.Printf("Step %s found input %s in %#v", self.Id, v.Source, state)
+			Debugf("Step %s found input %s in %#v", self.Id, v.Source, state)
 		}
 	}
 	return ready
@@ -155,6 +162,6 @@ func (self Step) BuildStepInput(state JSONDict) JSONDict {
 		}
 	}
 	out[INPUT_FIELD] = inputs
-	log.Printf("Input Built: %#v from %#v", out, self.In)
+	Debugf("Input Built: %#v from %#v", out, self.In)
 	return out
 }
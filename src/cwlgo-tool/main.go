@@ -5,20 +5,46 @@ import (
 	"cwl/engine"
 	"flag"
 	"fmt"
+	"golang.org/x/net/context"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 )
 
+// stringList collects the values of a repeated flag
+type stringList []string
+
+func (self *stringList) String() string {
+	return strings.Join(*self, ",")
+}
+
+func (self *stringList) Set(value string) error {
+	*self = append(*self, value)
+	return nil
+}
+
 func main() {
 	var version_flag = flag.Bool("version", false, "version")
 	var tmp_outdir_prefix_flag = flag.String("tmp-outdir-prefix", "./", "Temp output prefix")
 	var tmpdir_prefix_flag = flag.String("tmpdir-prefix", "/tmp", "Tempdir prefix")
-	var outdir = flag.String("outdir", "./", "Outdir")
-	var quiet_flag = flag.Bool("quiet", false, "quiet")
+	var outdir_flag = flag.String("outdir", "./", "Outdir")
+	var leave_tmpdir_flag = flag.Bool("leave-tmpdir", false, "Do not delete intermediate work directories")
+	var leave_outputs_flag = flag.Bool("leave-outputs", false, "Leave output files in their work directories instead of moving them to outdir")
+	var quiet_flag = flag.Bool("quiet", false, "Only log warnings and errors")
+	var debug_flag = flag.Bool("debug", false, "Log debug messages")
+	var verbosity_flag = flag.String("verbosity", "info", "Log level on stderr: debug, info, warning or error")
+	var log_file_flag = flag.String("log-file", "", "JSON lines log file (default: cwl.log.jsonl in the run directory, or in outdir when the run directory is temporary)")
+	var rundir_flag = flag.String("rundir", "", "Directory to persist run state in (default: a new directory under tmpdir-prefix, kept only if the run fails)")
+	var cachedir_flag = flag.String("cachedir", "", "Directory to cache job outputs in for reuse")
+	var resume_flag = flag.String("resume", "", "Resume the run persisted in this run directory")
+	var preserve_environment_flag = stringList{}
+	flag.Var(&preserve_environment_flag, "preserve-environment", "Pass on this environment variable to jobs, can be repeated")
+	var preserve_entire_environment_flag = flag.Bool("preserve-entire-environment", false, "Pass on the entire environment to jobs")
+	var max_cores_flag = flag.Int("max-cores", 0, "Cores shared by concurrent jobs (default: all cores of the machine)")
+	var max_ram_flag = flag.Int64("max-ram", 0, "RAM in MiB shared by concurrent jobs (default: all memory of the machine)")
+	var default_timeout_flag = flag.Duration("default-timeout", 0, "Time limit of jobs without a ToolTimeLimit, such as 30s or 2h (default: none)")
+	var default_network_access_flag = flag.Bool("default-network-access", false, "Let tools that don't declare NetworkAccess reach the network, as v1.0 tools could (default: containers get no network)")
 	flag.Parse()
 
 	if *version_flag {
@@ -26,38 +52,61 @@ func main() {
 		return
 	}
 
+	level, err := cwl.ParseLogLevel(*verbosity_flag)
+	if err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("%s\n", err))
+		os.Exit(1)
+	}
 	if *quiet_flag {
-		log.SetOutput(ioutil.Discard)
+		level = cwl.WARNING
+	}
+	if *debug_flag {
+		level = cwl.DEBUG
 	}
+	cwl.Logging.SetLevel(level)
 
 	tmp_outdir_prefix, _ := filepath.Abs(*tmp_outdir_prefix_flag)
 	tmpdir_prefix, _ := filepath.Abs(*tmpdir_prefix_flag)
+	outdir, _ := filepath.Abs(*outdir_flag)
 
 	config := cwl_engine.Config{
-		TmpOutdirPrefix: tmp_outdir_prefix,
-		TmpdirPrefix:    tmpdir_prefix,
-		Outdir:          *outdir,
-		Quiet:           *quiet_flag,
+		TmpOutdirPrefix:           tmp_outdir_prefix,
+		TmpdirPrefix:              tmpdir_prefix,
+		Outdir:                    outdir,
+		Quiet:                     *quiet_flag,
+		CacheDir:                  *cachedir_flag,
+		LeaveTmpdir:               *leave_tmpdir_flag,
+		LeaveOutputs:              *leave_outputs_flag,
+		PreserveEnvironment:       preserve_environment_flag,
+		PreserveEntireEnvironment: *preserve_entire_environment_flag,
+		MaxCores:                  *max_cores_flag,
+		MaxRam:                    *max_ram_flag,
+		DefaultTimeout:            *default_timeout_flag,
+		DefaultNetworkAccess:      *default_network_access_flag,
 	}
 
+	var runState *cwl_engine.RunState
 	cwl_path := flag.Arg(0)
+	if *resume_flag != "" {
+		var err error
+		runState, err = cwl_engine.LoadRunState(*resume_flag)
+		if err != nil {
+			os.Stderr.WriteString(fmt.Sprintf("Unable to resume run: %s\n", err))
+			os.Exit(1)
+		}
+		cwl_path = runState.Document
+	}
+	document := cwl_path
 	element_id := ""
 	if strings.Contains(cwl_path, "#") {
 		tmp := strings.Split(cwl_path, "#")
 		cwl_path = tmp[0]
 		element_id = tmp[1]
 	}
-	cwl_docs, err := cwl.Parse(cwl_path)
-	if err != nil {
-		os.Stderr.WriteString(fmt.Sprintf("Unable to parse CWL document: %s\n", err))
-		if _, ok := err.(cwl.UnsupportedRequirement); ok {
-			os.Exit(33)
-		}
-		os.Exit(1)
-	}
-	//log.Printf("CWLDoc: %#v", cwl_docs)
 	var inputs cwl.JSONDict
-	if len(flag.Args()) == 1 {
+	if runState != nil {
+		inputs = runState.Inputs
+	} else if len(flag.Args()) == 1 {
 		inputs = cwl.JSONDict{}
 	} else {
 		var err error
@@ -68,10 +117,80 @@ func main() {
 		}
 	}
 
+	//a run dir created here is only kept when the run fails, so it can be
+	//resumed
+	tmp_rundir := ""
+	if runState == nil {
+		rundir := *rundir_flag
+		if rundir == "" {
+			rundir, err = ioutil.TempDir(tmpdir_prefix, "cwlrun_")
+			if err != nil {
+				os.Stderr.WriteString(fmt.Sprintf("Unable to create run dir: %s\n", err))
+				os.Exit(1)
+			}
+			tmp_rundir = rundir
+		}
+		abs_path, _ := filepath.Abs(cwl_path)
+		if element_id != "" {
+			document = abs_path + "#" + element_id
+		} else {
+			document = abs_path
+		}
+		runState, err = cwl_engine.NewRunState(rundir, document, inputs)
+		if err != nil {
+			os.Stderr.WriteString(fmt.Sprintf("%s\n", err))
+			removeRunDir(tmp_rundir)
+			os.Exit(1)
+		}
+	}
+
+	//a temporary run dir is removed when the run succeeds, the log is
+	//kept next to the outputs instead
+	log_file := *log_file_flag
+	if log_file == "" && tmp_rundir != "" {
+		if err := os.MkdirAll(outdir, 0755); err != nil {
+			os.Stderr.WriteString(fmt.Sprintf("Unable to create outdir: %s\n", err))
+			removeRunDir(tmp_rundir)
+			os.Exit(1)
+		}
+		log_file = filepath.Join(outdir, "cwl.log.jsonl")
+	} else if log_file == "" {
+		log_file = filepath.Join(runState.RunDir, "cwl.log.jsonl")
+	}
+	log_out, err := os.OpenFile(log_file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("Unable to open log file: %s\n", err))
+		removeRunDir(tmp_rundir)
+		os.Exit(1)
+	}
+	//os.Exit skips deferred calls, the run log is closed before exiting
+	exit := func(code int) {
+		log_out.Close()
+		removeRunDir(tmp_rundir)
+		os.Exit(code)
+	}
+	//the run log keeps info level records even when stderr is quieter
+	if level < cwl.INFO {
+		cwl.Logging.SetJSONOutput(log_out, level)
+	} else {
+		cwl.Logging.SetJSONOutput(log_out, cwl.INFO)
+	}
+	run_id := filepath.Base(runState.RunDir)
+	cwl.Logging.With(cwl.LOG_RUN_ID, run_id).Infof("Run directory: %s", runState.RunDir)
+
+	cwl_docs, err := cwl.Parse(cwl_path)
+	if err != nil {
+		cwl.LogErrorf("Unable to parse CWL document: %s", err)
+		if _, ok := err.(cwl.UnsupportedRequirement); ok {
+			exit(33)
+		}
+		exit(1)
+	}
+
 	if cwl_docs.Main == "" {
 		if element_id == "" {
 			os.Stderr.WriteString(fmt.Sprintf("Need to define element ID\n"))
-			os.Exit(1)
+			exit(1)
 		}
 		cwl_docs.Main = element_id
 	}
@@ -84,56 +203,30 @@ func main() {
 	}
 	if !ok {
 		os.Stderr.WriteString(fmt.Sprintf("Element %s not found\n", cwl_docs.Main))
-		os.Exit(1)
+		exit(1)
 	}
-	log.Printf("STARTING RUN")
-	graphState := cwl_doc.NewGraphState(inputs)
-	for !cwl_doc.Done(graphState) {
-		log.Printf("StateGraph: %s", graphState.ToString())
-		readyCount := 0
-		for _, step := range cwl_doc.ReadySteps(graphState) {
-			job, err := cwl_doc.GenerateJob(step, graphState)
-			if err != nil {
-				log.Printf("%s", err)
-				os.Exit(1)
-			}
-			var runner cwl_engine.JobRunner
-			if job.JobType == cwl.EXPRESSION {
-				runner = cwl_engine.NewExpressionRunner(config)
-			} else {
-				if job.DockerImage != "" {
-					runner, _ = cwl_engine.NewDockerRunner(config)
-				} else {
-					runner, _ = cwl_engine.NewLocalRunner(config)
-				}
-			}
-			mapper := cwl_engine.RuntimeMapper{}
-			
-			task, err := cwl_engine.StartJob(job, runner, mapper)
-			if err != nil {
-				log.Printf("Runtime Error: %s", err)
-				os.Exit(1)
-			}
-
-			sleepTime := time.Microsecond
-			for !cwl_engine.JobDone(task, runner) {
-				time.Sleep(sleepTime)
-				if sleepTime < time.Second*10 {
-					sleepTime += time.Millisecond
-				}
-			}
-
-			out, _ := cwl_engine.CleanupJob(task, runner)
-			graphState = cwl_doc.UpdateStepResults(graphState, step, out)
-			readyCount += 1
-		}
-		if readyCount == 0 {
-			log.Printf("No jobs found")
-			return
-		}
+	executor, err := cwl_engine.NewExecutor(config, cwl_engine.DefaultRunnerFactory)
+	if err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("%s\n", err))
+		exit(1)
+	}
+	executor.RunState = runState
+	executor.RunID = run_id
+	executor.AddObserver(cwl_engine.NewLogObserver(cwl.Logging))
+	out, _, err := executor.Run(context.Background(), cwl_doc, inputs)
+	if err != nil {
+		tmp_rundir = ""
+		exit(1)
 	}
-	out := cwl_doc.GetResults(graphState)
-	log.Printf("doc results: %#v", out)
 	fmt.Printf("%s\n", string(out.ToString()))
+	log_out.Close()
+	removeRunDir(tmp_rundir)
+}
 
+// removeRunDir removes a run dir created for this run, dir is empty when
+// the run dir was given on the command line
+func removeRunDir(dir string) {
+	if dir != "" {
+		os.RemoveAll(dir)
+	}
 }